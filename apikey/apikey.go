@@ -0,0 +1,26 @@
+// Package apikey stores the API keys validated by the API key middleware.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Store returns the consumer an API key is issued to. Implementations must be safe for
+// concurrent use, and must not modify the consumers they return.
+type Store interface {
+	Lookup(ctx context.Context, key string) (*Consumer, bool)
+}
+
+// Consumer is the identity an API key is issued to, such as a partner
+type Consumer struct {
+	ID       string            `yaml:"id" json:"id"`
+	Metadata map[string]string `yaml:"metadata" json:"metadata"`
+}
+
+// Hash returns the hash of a key as stored by the stores, e.g. in key files
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
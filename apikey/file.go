@@ -0,0 +1,154 @@
+package apikey
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultReloadInterval is the interval between checks of the key file by default
+const defaultReloadInterval = 5 * time.Second
+
+// FileStore is a Store of the hashed keys of a YAML or JSON file, reloaded when the file changes:
+//
+//	keys:
+//	  - hash: sha256:4f9d...  # apikey.Hash of the key
+//	    id: partner-a
+//	    metadata:
+//	      plan: gold
+type FileStore struct {
+	path     string
+	interval time.Duration
+
+	mutex     sync.RWMutex
+	consumers map[string]*Consumer
+
+	// reloading serializes the checks of the file, checked and the file state being guarded by it
+	reloading sync.Mutex
+	checked   time.Time
+	modTime   time.Time
+	size      int64
+}
+
+type keyFile struct {
+	Keys []keyFileEntry `yaml:"keys"`
+}
+
+type keyFileEntry struct {
+	Hash     string `yaml:"hash"`
+	Consumer `yaml:",inline"`
+}
+
+// NewFileStore returns a store of the keys of the file at path, checked for changes every
+// reloadInterval, 5 seconds by default
+func NewFileStore(path string, reloadInterval time.Duration) (*FileStore, error) {
+	if reloadInterval == 0 {
+		reloadInterval = defaultReloadInterval
+	}
+
+	s := &FileStore{path: path, interval: reloadInterval}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.checked = time.Now()
+
+	return s, nil
+}
+
+// Lookup returns the consumer of key, reloading the file first if it changed
+func (s *FileStore) Lookup(ctx context.Context, key string) (*Consumer, bool) {
+	s.reload(ctx)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	consumer, ok := s.consumers[Hash(key)]
+	return consumer, ok
+}
+
+// reload loads the file if it changed since the last check, keeping the current keys if it is invalid.
+// Lookups do not wait for a reload in progress.
+func (s *FileStore) reload(ctx context.Context) {
+	if !s.reloading.TryLock() {
+		return
+	}
+	defer s.reloading.Unlock()
+
+	if time.Since(s.checked) < s.interval {
+		return
+	}
+
+	s.checked = time.Now()
+	if err := s.load(); err != nil {
+		slog.ErrorContext(ctx, "failed to reload API keys, keeping the current ones", "path", s.path, "error", err)
+	}
+}
+
+// load reads the file if its modification time or size changed
+func (s *FileStore) load() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+
+	if s.consumers != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	// JSON documents are YAML documents as well.
+	var file keyFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid key file %s: %w", s.path, err)
+	}
+
+	consumers := make(map[string]*Consumer, len(file.Keys))
+	for i, entry := range file.Keys {
+		hash, err := parseHash(entry.Hash)
+		if err != nil {
+			return fmt.Errorf("invalid key %d of %s: %w", i, s.path, err)
+		}
+
+		if entry.ID == "" {
+			return fmt.Errorf("key %d of %s has no consumer id", i, s.path)
+		}
+
+		consumer := entry.Consumer
+		consumers[hash] = &consumer
+	}
+
+	s.mutex.Lock()
+	s.consumers = consumers
+	s.mutex.Unlock()
+
+	s.modTime, s.size = info.ModTime(), info.Size()
+
+	return nil
+}
+
+// parseHash validates a hash of the file, returning it in the format of Hash
+func parseHash(hash string) (string, error) {
+	digest, ok := strings.CutPrefix(hash, "sha256:")
+	if !ok {
+		return "", fmt.Errorf("hash %q must start with sha256:", hash)
+	}
+
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("hash %q is not a hex-encoded SHA-256 digest", hash)
+	}
+
+	return "sha256:" + hex.EncodeToString(decoded), nil
+}
@@ -0,0 +1,98 @@
+package apikey_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/apikey"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("it should read the hashed keys of a YAML file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(`
+keys:
+  - hash: `+apikey.Hash("key-a")+`
+    id: partner-a
+    metadata:
+      plan: gold
+`), 0o600))
+
+		store, err := apikey.NewFileStore(path, 0)
+		require.NoError(t, err)
+
+		consumer, ok := store.Lookup(ctx, "key-a")
+		require.True(t, ok)
+		require.Equal(t, apikey.Consumer{ID: "partner-a", Metadata: map[string]string{"plan": "gold"}}, *consumer)
+
+		_, ok = store.Lookup(ctx, apikey.Hash("key-a"))
+		require.False(t, ok)
+	})
+
+	t.Run("it should read the hashed keys of a JSON file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"keys":[{"hash":"`+apikey.Hash("key-a")+`","id":"partner-a"}]}`), 0o600))
+
+		store, err := apikey.NewFileStore(path, 0)
+		require.NoError(t, err)
+
+		consumer, ok := store.Lookup(ctx, "key-a")
+		require.True(t, ok)
+		require.Equal(t, "partner-a", consumer.ID)
+	})
+
+	t.Run("it should return an error for invalid files", func(t *testing.T) {
+		for _, content := range []string{
+			`keys: [{hash: "4f9d", id: a}]`,
+			`keys: [{hash: "sha256:zz", id: a}]`,
+			`keys: [{hash: "` + apikey.Hash("key") + `"}]`,
+			`keys: {`,
+		} {
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+			_, err := apikey.NewFileStore(path, 0)
+			require.Error(t, err, content)
+		}
+
+		_, err := apikey.NewFileStore(filepath.Join(t.TempDir(), "missing.yaml"), 0)
+		require.Error(t, err)
+	})
+
+	t.Run("it should reload the file when it changes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.yaml")
+		write := func(content string) {
+			require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		}
+
+		write(`keys: [{hash: "` + apikey.Hash("key-a") + `", id: partner-a}]`)
+
+		store, err := apikey.NewFileStore(path, 10*time.Millisecond)
+		require.NoError(t, err)
+
+		write(`keys: [{hash: "` + apikey.Hash("key-b") + `", id: partner-b2}]`)
+		require.Eventually(t, func() bool {
+			_, ok := store.Lookup(ctx, "key-b")
+			return ok
+		}, time.Second, 5*time.Millisecond)
+
+		_, ok := store.Lookup(ctx, "key-a")
+		require.False(t, ok)
+
+		// Invalid files keep the current keys.
+		write(`keys: [{hash: invalid, id: partner-c}]`)
+		time.Sleep(20 * time.Millisecond)
+
+		consumer, ok := store.Lookup(ctx, "key-b")
+		require.True(t, ok)
+		require.Equal(t, "partner-b2", consumer.ID)
+	})
+}
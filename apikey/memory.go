@@ -0,0 +1,42 @@
+package apikey
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore is an in-memory Store keeping the hashes of the keys only
+type MemoryStore struct {
+	mutex     sync.RWMutex
+	consumers map[string]*Consumer
+}
+
+// NewMemoryStore returns an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{consumers: make(map[string]*Consumer)}
+}
+
+// Add issues key to the consumer, replacing its previous consumer if any
+func (s *MemoryStore) Add(key string, consumer Consumer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.consumers[Hash(key)] = &consumer
+}
+
+// Remove revokes key
+func (s *MemoryStore) Remove(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.consumers, Hash(key))
+}
+
+// Lookup returns the consumer of key
+func (s *MemoryStore) Lookup(_ context.Context, key string) (*Consumer, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	consumer, ok := s.consumers[Hash(key)]
+	return consumer, ok
+}
@@ -0,0 +1,45 @@
+package apikey_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/apikey"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("it should return the consumer of the added keys", func(t *testing.T) {
+		store := apikey.NewMemoryStore()
+		store.Add("key-a", apikey.Consumer{ID: "partner-a", Metadata: map[string]string{"plan": "gold"}})
+
+		consumer, ok := store.Lookup(ctx, "key-a")
+		require.True(t, ok)
+		require.Equal(t, "partner-a", consumer.ID)
+		require.Equal(t, "gold", consumer.Metadata["plan"])
+
+		_, ok = store.Lookup(ctx, "key-b")
+		require.False(t, ok)
+	})
+
+	t.Run("it should revoke the removed keys", func(t *testing.T) {
+		store := apikey.NewMemoryStore()
+		store.Add("key-a", apikey.Consumer{ID: "partner-a"})
+		store.Remove("key-a")
+
+		_, ok := store.Lookup(ctx, "key-a")
+		require.False(t, ok)
+	})
+}
+
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should return the SHA-256 digest of the key", func(t *testing.T) {
+		require.Equal(t, "sha256:2c70e12b7a0646f92279f427c7b38e7334d8e5389cff167a1dc30e73f826b683", apikey.Hash("key"))
+	})
+}
@@ -0,0 +1,78 @@
+// Package cache stores the HTTP responses cached by the cache middleware.
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Store holds cached responses by key. Implementations must be safe for concurrent use,
+// and must not modify the entries they return.
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, bool)
+	Set(ctx context.Context, key string, entry *Entry)
+	Delete(ctx context.Context, key string)
+}
+
+// Entry is a cached response along with its freshness information
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	// Vary lists the request headers selecting the variant of a response. An entry with
+	// Vary set and no status only indexes the variants stored under their own keys.
+	Vary []string
+
+	// StoredAt is when the response was received, InitialAge its age at that time
+	StoredAt   time.Time
+	InitialAge time.Duration
+	// Lifetime is how long the response is fresh, 0 requires revalidation on every use
+	Lifetime time.Duration
+
+	// StaleWhileRevalidate and StaleIfError are how long the response can be used once stale,
+	// while it is revalidated in the background or when the upstream fails
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// MustRevalidate forbids using the response once stale
+	MustRevalidate bool
+}
+
+// Age returns the age of the response at now
+func (e *Entry) Age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.StoredAt)
+}
+
+// Fresh reports whether the response can be used without revalidation at now
+func (e *Entry) Fresh(now time.Time) bool {
+	return e.Age(now) < e.Lifetime
+}
+
+// UsableWhileRevalidating reports whether the stale response can be used at now while it is revalidated
+func (e *Entry) UsableWhileRevalidating(now time.Time) bool {
+	return e.usableStale(now, e.StaleWhileRevalidate)
+}
+
+// UsableOnError reports whether the stale response can be used at now when the upstream fails
+func (e *Entry) UsableOnError(now time.Time) bool {
+	return e.usableStale(now, e.StaleIfError)
+}
+
+func (e *Entry) usableStale(now time.Time, window time.Duration) bool {
+	if e.MustRevalidate || window <= 0 {
+		return false
+	}
+
+	return e.Age(now)-e.Lifetime < window
+}
+
+// Expired reports whether the response can no longer be used at now, even stale
+func (e *Entry) Expired(now time.Time) bool {
+	if e.Fresh(now) || e.UsableWhileRevalidating(now) || e.UsableOnError(now) {
+		return false
+	}
+
+	// Responses with validators stay useful to revalidate them.
+	return e.Header.Get("ETag") == "" && e.Header.Get("Last-Modified") == ""
+}
@@ -0,0 +1,53 @@
+package cache_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntry(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	t.Run("it should be fresh during its lifetime", func(t *testing.T) {
+		entry := &cache.Entry{Header: http.Header{}, StoredAt: now.Add(-30 * time.Second), InitialAge: 10 * time.Second, Lifetime: time.Minute}
+
+		require.Equal(t, 40*time.Second, entry.Age(now))
+		require.True(t, entry.Fresh(now))
+		require.False(t, entry.Fresh(now.Add(20*time.Second)))
+	})
+
+	t.Run("it should be usable stale within its windows", func(t *testing.T) {
+		entry := &cache.Entry{
+			Header:               http.Header{},
+			StoredAt:             now.Add(-90 * time.Second),
+			Lifetime:             time.Minute,
+			StaleWhileRevalidate: time.Minute,
+			StaleIfError:         10 * time.Second,
+		}
+
+		require.False(t, entry.Fresh(now))
+		require.True(t, entry.UsableWhileRevalidating(now))
+		require.False(t, entry.UsableOnError(now))
+		require.False(t, entry.Expired(now))
+		require.True(t, entry.Expired(now.Add(time.Minute)))
+	})
+
+	t.Run("it should not be usable stale when it must be revalidated", func(t *testing.T) {
+		entry := &cache.Entry{Header: http.Header{}, StoredAt: now.Add(-90 * time.Second), Lifetime: time.Minute, StaleIfError: time.Hour, MustRevalidate: true}
+
+		require.False(t, entry.UsableOnError(now))
+	})
+
+	t.Run("it should not expire while it can be revalidated", func(t *testing.T) {
+		entry := &cache.Entry{Header: http.Header{"Etag": {`"v1"`}}, StoredAt: now.Add(-time.Hour)}
+
+		require.False(t, entry.Fresh(now))
+		require.False(t, entry.Expired(now))
+	})
+}
@@ -0,0 +1,56 @@
+package cache_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/cache"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("it should store and delete entries", func(t *testing.T) {
+		store := cache.NewMemoryStore(0)
+		store.Set(ctx, "a", &cache.Entry{Status: 200})
+
+		entry, ok := store.Get(ctx, "a")
+		require.True(t, ok)
+		require.Equal(t, 200, entry.Status)
+
+		store.Delete(ctx, "a")
+		_, ok = store.Get(ctx, "a")
+		require.False(t, ok)
+	})
+
+	t.Run("it should evict the least recently used entry", func(t *testing.T) {
+		store := cache.NewMemoryStore(2)
+		store.Set(ctx, "a", &cache.Entry{Status: 200})
+		store.Set(ctx, "b", &cache.Entry{Status: 200})
+
+		_, ok := store.Get(ctx, "a")
+		require.True(t, ok)
+
+		store.Set(ctx, "c", &cache.Entry{Status: 200})
+
+		require.Equal(t, 2, store.Len())
+		_, ok = store.Get(ctx, "b")
+		require.False(t, ok)
+		_, ok = store.Get(ctx, "a")
+		require.True(t, ok)
+	})
+
+	t.Run("it should replace existing entries", func(t *testing.T) {
+		store := cache.NewMemoryStore(1)
+		store.Set(ctx, "a", &cache.Entry{Status: 200})
+		store.Set(ctx, "a", &cache.Entry{Status: 404})
+
+		entry, ok := store.Get(ctx, "a")
+		require.True(t, ok)
+		require.Equal(t, 404, entry.Status)
+		require.Equal(t, 1, store.Len())
+	})
+}
@@ -4,12 +4,15 @@ package heimdall
 import (
 	"context"
 	"log/slog"
+	"net/http"
 
 	"github.com/arthurdotwork/heimdall/internal/config"
 	internalMiddleware "github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/internal/problem"
 	"github.com/arthurdotwork/heimdall/internal/proxy"
 	"github.com/arthurdotwork/heimdall/internal/router"
 	"github.com/arthurdotwork/heimdall/internal/server"
+	"github.com/arthurdotwork/heimdall/internal/upstream"
 )
 
 // Gateway represents an API gateway that can route and proxy requests
@@ -28,6 +31,12 @@ type (
 	EndpointConfig = config.EndpointConfig
 	Middleware     = internalMiddleware.Middleware
 	MiddlewareFunc = internalMiddleware.Func
+
+	UpstreamConfig   = config.UpstreamConfig
+	DiscoveryConfig  = config.DiscoveryConfig
+	Discoverer       = upstream.Discoverer
+	DiscovererFunc   = upstream.DiscovererFunc
+	DiscoveryFactory = upstream.Factory
 )
 
 // New creates a new gateway instance
@@ -48,8 +57,15 @@ func NewWithRegistry(configPath string, registry *internalMiddleware.Registry) (
 		return nil, err
 	}
 
+	errorRenderer, err := problem.NewRenderer(cfg.Gateway.Errors)
+	if err != nil {
+		return nil, err
+	}
+
 	p := proxy.NewHandler(r)
+	p.SetErrorRenderer(errorRenderer)
 	s := server.New(cfg.Gateway, p)
+	s.RegisterOnShutdown(p.CloseUpgrades)
 
 	// Initialize global middleware
 	globalMiddlewares := internalMiddleware.NewChain()
@@ -81,6 +97,20 @@ func NewWithRegistry(configPath string, registry *internalMiddleware.Registry) (
 
 // Start starts the gateway
 func (g *Gateway) Start(ctx context.Context) error {
+	// Handler targets are registered after New, so they are only known to be served from here.
+	if err := g.proxy.CheckHandlers(g.router.Handlers()); err != nil {
+		return err
+	}
+
+	// Upstream pools are discovered before serving, then refreshed until the gateway stops.
+	for _, pool := range g.router.Pools() {
+		if err := pool.Refresh(ctx); err != nil {
+			slog.WarnContext(ctx, "failed to discover upstream targets", "error", err)
+		}
+
+		go pool.Run(ctx)
+	}
+
 	return g.server.Start(ctx)
 }
 
@@ -89,6 +119,11 @@ func (g *Gateway) Config() *config.Config {
 	return g.config
 }
 
+// ActiveUpgradedConnections returns the number of active upgraded (e.g. WebSocket) connections
+func (g *Gateway) ActiveUpgradedConnections() int {
+	return g.proxy.ActiveUpgrades()
+}
+
 // Use adds a middleware to the global middleware chain
 func (g *Gateway) Use(middleware Middleware) *Gateway {
 	g.globalMiddlewares.Add(middleware)
@@ -109,6 +144,13 @@ func (g *Gateway) RegisterMiddleware(name string, middleware Middleware) error {
 	return g.registry.Register(name, middleware)
 }
 
+// RegisterHandler registers an in-process handler serving the endpoints whose target is
+// handler://name. These endpoints go through the global and endpoint middlewares as well.
+// Every handler target must be registered before Start.
+func (g *Gateway) RegisterHandler(name string, handler http.Handler) error {
+	return g.proxy.RegisterHandler(name, handler)
+}
+
 // GetMiddleware gets a middleware from the gateway's registry
 func (g *Gateway) GetMiddleware(name string) (Middleware, bool) {
 	return g.registry.Get(name)
@@ -122,6 +164,12 @@ func RegisterMiddleware(name string, middleware Middleware) error {
 	return defaultRegistry.Register(name, middleware)
 }
 
+// RegisterDiscovery registers a custom discovery type, e.g. a service registry client,
+// usable as the type of the discovery of endpoint upstreams. It must be called before New.
+func RegisterDiscovery(name string, factory DiscoveryFactory) error {
+	return upstream.RegisterDiscovery(name, factory)
+}
+
 // LoadFromFile loads configuration from a file
 func LoadFromFile(path string) (*Config, error) {
 	return config.LoadFromFile(path)
@@ -3,6 +3,7 @@ package heimdall_test
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -585,3 +586,97 @@ func TestGateway_GetMiddleware(t *testing.T) {
 		require.Nil(t, middleware)
 	})
 }
+
+func TestGateway_RegisterHandler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should fail to start when a handler target is not registered", func(t *testing.T) {
+		config := map[string]any{
+			"gateway": map[string]any{
+				"port": 8101,
+			},
+			"endpoints": []map[string]any{
+				{
+					"path":   "/whoami",
+					"target": "handler://whoami",
+					"method": "GET",
+				},
+			},
+		}
+
+		configPath := createTempConfig(t, config)
+
+		gateway, err := heimdall.New(configPath)
+		require.NoError(t, err)
+
+		err = gateway.Start(context.Background())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), `handler "whoami" is not registered`)
+	})
+
+	t.Run("it should serve handler targets through the middleware chains", func(t *testing.T) {
+		port := 8100
+		config := map[string]any{
+			"gateway": map[string]any{
+				"port": port,
+			},
+			"endpoints": []map[string]any{
+				{
+					"path":   "/whoami",
+					"target": "handler://whoami",
+					"method": "GET",
+				},
+			},
+		}
+
+		configPath := createTempConfig(t, config)
+
+		gateway, err := heimdall.New(configPath)
+		require.NoError(t, err)
+
+		err = gateway.RegisterHandler("whoami", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("middleware:" + r.Header.Get("X-Global-Middleware"))) //nolint:errcheck
+		}))
+		require.NoError(t, err)
+
+		err = gateway.RegisterHandler("whoami", http.NotFoundHandler())
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already registered")
+
+		gateway.UseFunc(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Set("X-Global-Middleware", "true")
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- gateway.Start(ctx)
+		}()
+
+		time.Sleep(200 * time.Millisecond)
+
+		client := &http.Client{Timeout: 5 * time.Second}
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/whoami", port))
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "middleware:true", string(body))
+
+		cancel()
+
+		select {
+		case err := <-errCh:
+			require.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("server did not shut down within expected time")
+		}
+	})
+}
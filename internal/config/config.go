@@ -1,7 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"net/http"
 	"os"
+	"slices"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,6 +16,22 @@ type GatewayConfig struct {
 	WriteTimeout    time.Duration `yaml:"write_timeout"`
 	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 	Middlewares     []string      `yaml:"middlewares"` // Global middlewares
+	// Header policies inherited by every endpoint
+	RequestHeaders  HeaderPolicyConfig `yaml:"request_headers"`
+	ResponseHeaders HeaderPolicyConfig `yaml:"response_headers"`
+	// Header forwarding defaults inherited by every endpoint
+	HeaderMode       string   `yaml:"header_mode"`
+	EssentialHeaders []string `yaml:"essential_headers"`
+	// MaxBodyBytes is the default request body size limit, 0 means unlimited
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// MaxResponseBytes is the default upstream response body size limit, 0 means unlimited
+	MaxResponseBytes int64 `yaml:"max_response_bytes"`
+	// H2C accepts HTTP/2 over cleartext connections. Enabled automatically when an endpoint uses gRPC.
+	H2C bool `yaml:"h2c"`
+	// Errors configures the rendering of the errors generated by the gateway, inherited by every endpoint
+	Errors ErrorsConfig `yaml:"errors"`
+	// NoProxy lists the targets reached directly rather than through the egress proxy of their endpoint
+	NoProxy []string `yaml:"no_proxy"`
 }
 
 type EndpointConfig struct {
@@ -22,6 +41,358 @@ type EndpointConfig struct {
 	Headers        map[string][]string `yaml:"headers"`
 	AllowedHeaders []string            `yaml:"allowed_headers"`
 	Middlewares    []string            `yaml:"middlewares"` // Per-endpoint middlewares
+	// Header policies applied to the upstream request and to the upstream response
+	RequestHeaders  HeaderPolicyConfig `yaml:"request_headers"`
+	ResponseHeaders HeaderPolicyConfig `yaml:"response_headers"`
+	// HeaderMode is either "allowlist" (forward only allowed and essential headers)
+	// or "passthrough" (forward everything except denied headers).
+	HeaderMode string `yaml:"header_mode"`
+	// EssentialHeaders are always forwarded in allowlist mode. Defaults to DefaultEssentialHeaders.
+	EssentialHeaders []string `yaml:"essential_headers"`
+	// MaxBodyBytes limits the request body size. Defaults to the gateway limit, -1 lifts it.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// MaxResponseBytes limits the upstream response body size. Defaults to the gateway limit.
+	MaxResponseBytes int64 `yaml:"max_response_bytes"`
+	// AllowedStatuses are the accepted upstream statuses, as codes (404) or classes (2xx), all by default
+	AllowedStatuses []string `yaml:"allowed_statuses"`
+	// AllowedContentTypes are the accepted upstream media types ("text/*" matches every text type), all by default
+	AllowedContentTypes []string `yaml:"allowed_content_types"`
+	// Upgrade enables HTTP Upgrade (e.g. WebSocket) proxying on the endpoint
+	Upgrade *UpgradeConfig `yaml:"upgrade"`
+	// FlushInterval is the interval between response flushes, -1 flushes after every write
+	FlushInterval FlushInterval `yaml:"flush_interval"`
+	// Protocol is the protocol of the endpoint: http (default), grpc, or grpc-web
+	// (gRPC-Web clients translated to gRPC towards the upstream)
+	Protocol string `yaml:"protocol"`
+	// TLS configures the connection to HTTPS upstreams
+	TLS *TLSConfig `yaml:"tls"`
+	// Upstream balances requests over a pool of targets, static or discovered
+	Upstream *UpstreamConfig `yaml:"upstream"`
+	// Errors overrides the error templates of the gateway for this endpoint
+	Errors ErrorsConfig `yaml:"errors"`
+	// Aggregate turns the endpoint into an aggregation of concurrent sub-requests, whose
+	// JSON responses are merged under the name of each part
+	Aggregate *AggregateConfig `yaml:"aggregate"`
+	// Cache configures the cache middleware for this endpoint
+	Cache *CacheConfig `yaml:"cache"`
+	// Coalesce collapses concurrent identical GET and HEAD requests into a single upstream call,
+	// requests being identical when their method, URL and CoalesceVary headers are
+	Coalesce     bool     `yaml:"coalesce"`
+	CoalesceVary []string `yaml:"coalesce_vary"`
+	// Fallback receives the requests the target fails, e.g. a replica in another region
+	Fallback *FallbackConfig `yaml:"fallback"`
+	// Hedge sends a second request to another target when the first one is slow to answer
+	Hedge *HedgeConfig `yaml:"hedge"`
+	// EgressProxy is the forward proxy the targets are reached through. Defaults to the one of the upstream.
+	EgressProxy *EgressProxyConfig `yaml:"egress_proxy"`
+	// PreserveHost forwards the Host header of the client instead of the target host,
+	// HostOverride forwards a fixed Host header. The TLS server name follows, unless tls.server_name is set.
+	PreserveHost bool   `yaml:"preserve_host"`
+	HostOverride string `yaml:"host_override"`
+	// ResponseRewrite maps upstream URLs in Location, Content-Location and Set-Cookie headers to the gateway
+	ResponseRewrite *ResponseRewriteConfig `yaml:"response_rewrite"`
+}
+
+// FallbackConfig configures the target receiving the requests failed by the primary target
+type FallbackConfig struct {
+	// Target is the URL of the fallback, whose path and query may contain {param} placeholders
+	Target string `yaml:"target"`
+	// Statuses of the primary target sending the request to the fallback, as codes (503) or
+	// classes (5xx), 502, 503 and 504 by default. Transport errors always do.
+	Statuses []string `yaml:"statuses"`
+}
+
+// HedgeConfig configures request hedging. Only requests with an idempotent method and a
+// replayable body are hedged, the first response wins and the other request is canceled.
+type HedgeConfig struct {
+	// Delay after which the hedged request is sent. With a Percentile, it is used until enough latencies are observed.
+	Delay time.Duration `yaml:"delay"`
+	// Percentile of the recently observed latencies used as delay, e.g. 95, 0 uses the fixed delay only
+	Percentile float64 `yaml:"percentile"`
+	// Budget is the maximum ratio of hedged requests, 0.1 by default
+	Budget float64 `yaml:"budget"`
+}
+
+// ResponseRewriteConfig configures the rewriting of upstream URLs in response headers,
+// similar to nginx proxy_redirect, proxy_cookie_domain and proxy_cookie_path
+type ResponseRewriteConfig struct {
+	// Auto maps the origin and path of the target to the gateway host and endpoint path in
+	// Location and Content-Location, removes cookie domains of the target host and maps cookie paths
+	Auto bool `yaml:"auto"`
+	// Location maps URL prefixes of Location and Content-Location, before the automatic mapping
+	Location []RewriteRule `yaml:"location"`
+	// CookieDomain maps Set-Cookie domains, an empty replacement removing the attribute
+	CookieDomain []RewriteRule `yaml:"cookie_domain"`
+	// CookiePath maps Set-Cookie path prefixes
+	CookiePath []RewriteRule `yaml:"cookie_path"`
+}
+
+// RewriteRule replaces From by To
+type RewriteRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// EgressProxyConfig configures an outbound proxy. HTTPS targets are tunneled with CONNECT,
+// HTTP requests are sent to the proxy in absolute form.
+type EgressProxyConfig struct {
+	// URL of the proxy, e.g. http://proxy.corp:3128
+	URL string `yaml:"url"`
+	// Username and Password authenticate to the proxy with basic auth
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// NoProxy lists the targets reached directly: host names (with their subdomains), .domain
+	// suffixes, IP addresses, CIDR ranges, optionally with a :port, or * for every target.
+	// The no_proxy list of the gateway is appended to it.
+	NoProxy []string `yaml:"no_proxy"`
+}
+
+// CacheConfig overrides the behavior of the cache middleware for an endpoint
+type CacheConfig struct {
+	// TTL replaces the freshness lifetime announced by the upstream, 0 keeps it
+	TTL time.Duration `yaml:"ttl"`
+	// KeyHeaders are request headers whose values are added to the cache key
+	KeyHeaders []string `yaml:"key_headers"`
+	// Disabled bypasses the cache for the endpoint
+	Disabled bool `yaml:"disabled"`
+}
+
+// AggregateConfig configures an aggregation endpoint
+type AggregateConfig struct {
+	Parts []AggregatePartConfig `yaml:"parts"`
+	// Timeout is the default timeout of the parts, 0 means no timeout
+	Timeout time.Duration `yaml:"timeout"`
+	// OnError is the policy when a part fails: fail (default) fails the whole request, null returns null for the part
+	OnError string `yaml:"on_error"`
+}
+
+// AggregatePartConfig is a sub-request of an aggregation endpoint. Its target can reference
+// the path parameters of the endpoint, e.g. http://users/users/{id}.
+type AggregatePartConfig struct {
+	Name    string              `yaml:"name"`
+	Target  string              `yaml:"target"`
+	Method  string              `yaml:"method"`
+	Headers map[string][]string `yaml:"headers"`
+	Timeout time.Duration       `yaml:"timeout"`
+}
+
+const (
+	AggregateOnErrorFail = "fail"
+	AggregateOnErrorNull = "null"
+)
+
+// ErrorsConfig configures how errors generated by the gateway are rendered.
+// Errors are rendered as application/problem+json (RFC 7807) unless a template matches their status.
+type ErrorsConfig struct {
+	Templates map[int]ErrorTemplateConfig `yaml:"templates"`
+}
+
+// ErrorTemplateConfig is a Go template rendering an error, with the fields of the problem
+// (.Type, .Title, .Status, .Detail, .Instance, .Code and .RequestID) as data.
+type ErrorTemplateConfig struct {
+	// ContentType of the rendered error. HTML templates are escaped as such.
+	ContentType string `yaml:"content_type"`
+	// Body is the inline template, File a file containing it
+	Body string `yaml:"body"`
+	File string `yaml:"file"`
+}
+
+// Inherit merges the parent templates into these ones, templates of this configuration
+// taking precedence for the same status.
+func (e ErrorsConfig) Inherit(parent ErrorsConfig) ErrorsConfig {
+	if len(parent.Templates) == 0 {
+		return e
+	}
+
+	templates := make(map[int]ErrorTemplateConfig, len(parent.Templates)+len(e.Templates))
+	for status, template := range parent.Templates {
+		templates[status] = template
+	}
+
+	for status, template := range e.Templates {
+		templates[status] = template
+	}
+
+	return ErrorsConfig{Templates: templates}
+}
+
+// UpstreamConfig is a pool of targets sharing the scheme and path of the endpoint target
+type UpstreamConfig struct {
+	// Targets are static targets (host:port or URLs), always part of the pool
+	Targets []string `yaml:"targets"`
+	// Discovery resolves the targets of the pool and refreshes them periodically
+	Discovery *DiscoveryConfig `yaml:"discovery"`
+	// EgressProxy is the forward proxy the targets of the pool are reached through
+	EgressProxy *EgressProxyConfig `yaml:"egress_proxy"`
+}
+
+// DiscoveryConfig configures the discovery of upstream targets
+type DiscoveryConfig struct {
+	// Type is the discovery type: dns (A/AAAA), dns-srv, file, or a registered custom type
+	Type string `yaml:"type"`
+	// Name is the host name resolved by dns, or the SRV record name (_service._proto.name) resolved by dns-srv
+	Name string `yaml:"name"`
+	// Port is the port of the addresses resolved by dns. Defaults to the port of the endpoint target.
+	Port int `yaml:"port"`
+	// File is a JSON or YAML file listing the targets, used by file
+	File string `yaml:"file"`
+	// RefreshInterval is the interval between two discoveries (30s for dns, 5s for file)
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Options are free-form settings of custom discovery types
+	Options map[string]string `yaml:"options"`
+}
+
+const (
+	DiscoveryDNS    = "dns"
+	DiscoveryDNSSRV = "dns-srv"
+	DiscoveryFile   = "file"
+)
+
+// TLSConfig configures TLS towards an upstream. Certificate files are reloaded when they change.
+type TLSConfig struct {
+	// CAFile is a PEM bundle of the authorities trusted to sign the upstream certificate
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate and key presented for mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name used for SNI and certificate verification
+	ServerName string `yaml:"server_name"`
+	// MinVersion is the minimum TLS version: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string `yaml:"min_version"`
+	// InsecureSkipVerify disables certificate verification, for development only
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+const (
+	ProtocolHTTP    = "http"
+	ProtocolGRPC    = "grpc"
+	ProtocolGRPCWeb = "grpc-web"
+)
+
+// IsGRPC reports whether the protocol reaches the upstream over gRPC
+func IsGRPC(protocol string) bool {
+	return protocol == ProtocolGRPC || protocol == ProtocolGRPCWeb
+}
+
+// FlushInterval is a duration that also accepts -1 to flush immediately after each write
+type FlushInterval time.Duration
+
+// UnmarshalYAML decodes either -1 or a duration string such as "100ms"
+func (f *FlushInterval) UnmarshalYAML(value *yaml.Node) error {
+	var n int
+	if err := value.Decode(&n); err == nil {
+		if n != -1 && n != 0 {
+			return fmt.Errorf("invalid flush interval %d: use -1 or a duration", n)
+		}
+
+		*f = FlushInterval(n)
+		return nil
+	}
+
+	var d time.Duration
+	if err := value.Decode(&d); err != nil {
+		return err
+	}
+
+	*f = FlushInterval(d)
+	return nil
+}
+
+// UpgradeConfig configures HTTP Upgrade proxying for an endpoint
+type UpgradeConfig struct {
+	// Protocols lists the accepted Upgrade protocols. Defaults to websocket.
+	Protocols []string `yaml:"protocols"`
+	// IdleTimeout closes upgraded connections without traffic for this long, 0 disables it
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+const (
+	HeaderModeAllowlist   = "allowlist"
+	HeaderModePassthrough = "passthrough"
+)
+
+// DefaultEssentialHeaders are the protocol-essential headers forwarded by default,
+// whatever the allowed headers of the endpoint.
+var DefaultEssentialHeaders = []string{
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Content-Type",
+	"Content-Length",
+	"Content-Encoding",
+	"Content-Language",
+	"Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+}
+
+// HeaderPolicyConfig describes how headers are filtered and rewritten.
+// Allow, Deny and Remove accept glob patterns (e.g. "X-Tenant-*") matched case-insensitively.
+type HeaderPolicyConfig struct {
+	Allow  []string            `yaml:"allow"`
+	Deny   []string            `yaml:"deny"`
+	Remove []string            `yaml:"remove"`
+	Rename map[string]string   `yaml:"rename"`
+	Set    map[string]string   `yaml:"set"`
+	Add    map[string][]string `yaml:"add"`
+}
+
+// IsZero reports whether the policy is empty
+func (h HeaderPolicyConfig) IsZero() bool {
+	return len(h.Allow) == 0 && len(h.Deny) == 0 && len(h.Remove) == 0 &&
+		len(h.Rename) == 0 && len(h.Set) == 0 && len(h.Add) == 0
+}
+
+// Inherit merges a parent policy into this one. Pattern lists are combined, and
+// entries of this policy take precedence over the parent's for the same header.
+func (h HeaderPolicyConfig) Inherit(parent HeaderPolicyConfig) HeaderPolicyConfig {
+	return HeaderPolicyConfig{
+		Allow:  mergeLists(parent.Allow, h.Allow),
+		Deny:   mergeLists(parent.Deny, h.Deny),
+		Remove: mergeLists(parent.Remove, h.Remove),
+		Rename: mergeHeaderMaps(parent.Rename, h.Rename),
+		Set:    mergeHeaderMaps(parent.Set, h.Set),
+		Add:    mergeHeaderMaps(parent.Add, h.Add),
+	}
+}
+
+func mergeLists(parent, child []string) []string {
+	if len(parent) == 0 {
+		return child
+	}
+
+	seen := make(map[string]struct{}, len(parent)+len(child))
+	merged := make([]string, 0, len(parent)+len(child))
+	for _, value := range append(append([]string{}, parent...), child...) {
+		if _, ok := seen[value]; ok {
+			continue
+		}
+
+		seen[value] = struct{}{}
+		merged = append(merged, value)
+	}
+
+	return merged
+}
+
+func mergeHeaderMaps[V any](parent, child map[string]V) map[string]V {
+	if len(parent) == 0 {
+		return child
+	}
+
+	merged := make(map[string]V, len(parent)+len(child))
+	for key, value := range parent {
+		merged[http.CanonicalHeaderKey(key)] = value
+	}
+
+	for key, value := range child {
+		merged[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return merged
 }
 
 type Config struct {
@@ -60,5 +431,87 @@ func (c *Config) WithDefaults() *Config {
 		c.Gateway.ShutdownTimeout = 10 * time.Second
 	}
 
+	if c.Gateway.HeaderMode == "" {
+		c.Gateway.HeaderMode = HeaderModeAllowlist
+	}
+
+	if c.Gateway.EssentialHeaders == nil {
+		c.Gateway.EssentialHeaders = DefaultEssentialHeaders
+	}
+
+	for i := range c.Endpoints {
+		endpoint := &c.Endpoints[i]
+		if endpoint.Protocol == "" {
+			endpoint.Protocol = ProtocolHTTP
+		}
+
+		if endpoint.Protocol == ProtocolGRPC {
+			c.Gateway.H2C = true
+		}
+
+		if IsGRPC(endpoint.Protocol) && endpoint.Method == "" {
+			endpoint.Method = http.MethodPost
+		}
+
+		if endpoint.HeaderMode == "" {
+			endpoint.HeaderMode = c.Gateway.HeaderMode
+		}
+
+		if endpoint.EssentialHeaders == nil {
+			endpoint.EssentialHeaders = c.Gateway.EssentialHeaders
+		}
+
+		// -1 opts the endpoint out of the gateway limit, e.g. for uploads.
+		if endpoint.MaxBodyBytes == 0 {
+			endpoint.MaxBodyBytes = c.Gateway.MaxBodyBytes
+		}
+
+		if endpoint.MaxResponseBytes == 0 {
+			endpoint.MaxResponseBytes = c.Gateway.MaxResponseBytes
+		}
+
+		if endpoint.Upgrade != nil && len(endpoint.Upgrade.Protocols) == 0 {
+			endpoint.Upgrade.Protocols = []string{"websocket"}
+		}
+
+		if endpoint.Upstream != nil && endpoint.Upstream.Discovery != nil && endpoint.Upstream.Discovery.RefreshInterval == 0 {
+			endpoint.Upstream.Discovery.RefreshInterval = 30 * time.Second
+			if endpoint.Upstream.Discovery.Type == DiscoveryFile {
+				endpoint.Upstream.Discovery.RefreshInterval = 5 * time.Second
+			}
+		}
+
+		if endpoint.Aggregate != nil {
+			if endpoint.Aggregate.OnError == "" {
+				endpoint.Aggregate.OnError = AggregateOnErrorFail
+			}
+
+			for j := range endpoint.Aggregate.Parts {
+				part := &endpoint.Aggregate.Parts[j]
+				if part.Method == "" {
+					part.Method = http.MethodGet
+				}
+
+				if part.Timeout == 0 {
+					part.Timeout = endpoint.Aggregate.Timeout
+				}
+			}
+		}
+
+		endpoint.RequestHeaders = endpoint.RequestHeaders.Inherit(c.Gateway.RequestHeaders)
+		endpoint.ResponseHeaders = endpoint.ResponseHeaders.Inherit(c.Gateway.ResponseHeaders)
+		endpoint.Errors = endpoint.Errors.Inherit(c.Gateway.Errors)
+
+		if endpoint.EgressProxy == nil && endpoint.Upstream != nil {
+			endpoint.EgressProxy = endpoint.Upstream.EgressProxy
+		}
+
+		if endpoint.EgressProxy != nil {
+			egressProxy := *endpoint.EgressProxy
+			egressProxy.NoProxy = append(slices.Clone(egressProxy.NoProxy), c.Gateway.NoProxy...)
+			endpoint.EgressProxy = &egressProxy
+		}
+	}
+
 	return c
 }
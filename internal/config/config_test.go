@@ -1,6 +1,7 @@
 package config_test
 
 import (
+	"net/http"
 	"os"
 	"testing"
 	"time"
@@ -45,6 +46,27 @@ func TestConfig_LoadFromFile(t *testing.T) {
 		require.Nil(t, loadedCfg)
 	})
 
+	t.Run("it should parse flush intervals", func(t *testing.T) {
+		cfg := map[string]any{"endpoints": []map[string]any{
+			{"path": "/immediate", "flush_interval": -1},
+			{"path": "/periodic", "flush_interval": "100ms"},
+		}}
+
+		configPath := createTempConfig(t, cfg)
+		loadedCfg, err := config.LoadFromFile(configPath)
+		require.NoError(t, err)
+		require.Equal(t, config.FlushInterval(-1), loadedCfg.Endpoints[0].FlushInterval)
+		require.Equal(t, config.FlushInterval(100*time.Millisecond), loadedCfg.Endpoints[1].FlushInterval)
+	})
+
+	t.Run("it should return an error for an invalid flush interval", func(t *testing.T) {
+		cfg := map[string]any{"endpoints": []map[string]any{{"path": "/", "flush_interval": 10}}}
+
+		configPath := createTempConfig(t, cfg)
+		_, err := config.LoadFromFile(configPath)
+		require.Error(t, err)
+	})
+
 	t.Run("it should parse and return the config", func(t *testing.T) {
 		cfg := map[string]any{"gateway": map[string]any{"port": 8080}}
 
@@ -70,6 +92,135 @@ func TestConfig_WithDefaults(t *testing.T) {
 		require.Equal(t, 5*time.Second, cfg.Gateway.ReadTimeout)
 		require.Equal(t, 5*time.Second, cfg.Gateway.WriteTimeout)
 		require.Equal(t, 10*time.Second, cfg.Gateway.ShutdownTimeout)
+		require.Equal(t, config.HeaderModeAllowlist, cfg.Gateway.HeaderMode)
+		require.Equal(t, config.DefaultEssentialHeaders, cfg.Gateway.EssentialHeaders)
+	})
+
+	t.Run("it should let endpoints inherit or override the header forwarding defaults", func(t *testing.T) {
+		cfg := &config.Config{
+			Gateway: config.GatewayConfig{EssentialHeaders: []string{"Content-Type"}},
+			Endpoints: []config.EndpointConfig{
+				{Path: "/inherit"},
+				{Path: "/override", HeaderMode: config.HeaderModePassthrough, EssentialHeaders: []string{}},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.Equal(t, config.HeaderModeAllowlist, cfg.Endpoints[0].HeaderMode)
+		require.Equal(t, []string{"Content-Type"}, cfg.Endpoints[0].EssentialHeaders)
+		require.Equal(t, config.HeaderModePassthrough, cfg.Endpoints[1].HeaderMode)
+		require.Empty(t, cfg.Endpoints[1].EssentialHeaders)
+	})
+
+	t.Run("it should enable h2c and default to POST for gRPC endpoints", func(t *testing.T) {
+		cfg := &config.Config{
+			Endpoints: []config.EndpointConfig{
+				{Path: "/http"},
+				{Path: "/helloworld.Greeter/", Protocol: config.ProtocolGRPC},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.True(t, cfg.Gateway.H2C)
+		require.Equal(t, config.ProtocolHTTP, cfg.Endpoints[0].Protocol)
+		require.Empty(t, cfg.Endpoints[0].Method)
+		require.Equal(t, http.MethodPost, cfg.Endpoints[1].Method)
+	})
+
+	t.Run("it should set the default discovery refresh intervals", func(t *testing.T) {
+		cfg := &config.Config{
+			Endpoints: []config.EndpointConfig{
+				{Path: "/dns", Upstream: &config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: config.DiscoveryDNS}}},
+				{Path: "/file", Upstream: &config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: config.DiscoveryFile}}},
+				{Path: "/custom", Upstream: &config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: config.DiscoveryDNS, RefreshInterval: time.Minute}}},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.Equal(t, 30*time.Second, cfg.Endpoints[0].Upstream.Discovery.RefreshInterval)
+		require.Equal(t, 5*time.Second, cfg.Endpoints[1].Upstream.Discovery.RefreshInterval)
+		require.Equal(t, time.Minute, cfg.Endpoints[2].Upstream.Discovery.RefreshInterval)
+	})
+
+	t.Run("it should merge gateway error templates into endpoints", func(t *testing.T) {
+		cfg := &config.Config{
+			Gateway: config.GatewayConfig{Errors: config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{
+				404: {Body: "gateway 404"},
+				502: {Body: "gateway 502"},
+			}}},
+			Endpoints: []config.EndpointConfig{
+				{Path: "/", Errors: config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{502: {Body: "endpoint 502"}}}},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.Equal(t, map[int]config.ErrorTemplateConfig{
+			404: {Body: "gateway 404"},
+			502: {Body: "endpoint 502"},
+		}, cfg.Endpoints[0].Errors.Templates)
+	})
+
+	t.Run("it should set the aggregate defaults", func(t *testing.T) {
+		cfg := &config.Config{
+			Endpoints: []config.EndpointConfig{{
+				Path: "/screens/home",
+				Aggregate: &config.AggregateConfig{
+					Timeout: time.Second,
+					Parts: []config.AggregatePartConfig{
+						{Name: "profile"},
+						{Name: "orders", Method: http.MethodPost, Timeout: time.Minute},
+					},
+				},
+			}},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		aggregate := cfg.Endpoints[0].Aggregate
+		require.Equal(t, config.AggregateOnErrorFail, aggregate.OnError)
+		require.Equal(t, config.AggregatePartConfig{Name: "profile", Method: http.MethodGet, Timeout: time.Second}, aggregate.Parts[0])
+		require.Equal(t, config.AggregatePartConfig{Name: "orders", Method: http.MethodPost, Timeout: time.Minute}, aggregate.Parts[1])
+	})
+
+	t.Run("it should inherit the egress proxy of the upstream and the no_proxy list of the gateway", func(t *testing.T) {
+		upstreamProxy := &config.EgressProxyConfig{URL: "http://proxy.corp:3128", NoProxy: []string{"internal.corp"}}
+		cfg := &config.Config{
+			Gateway: config.GatewayConfig{NoProxy: []string{"10.0.0.0/8"}},
+			Endpoints: []config.EndpointConfig{
+				{Path: "/pooled", Upstream: &config.UpstreamConfig{EgressProxy: upstreamProxy}},
+				{Path: "/direct"},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.Equal(t, "http://proxy.corp:3128", cfg.Endpoints[0].EgressProxy.URL)
+		require.Equal(t, []string{"internal.corp", "10.0.0.0/8"}, cfg.Endpoints[0].EgressProxy.NoProxy)
+		require.Equal(t, []string{"internal.corp"}, upstreamProxy.NoProxy)
+		require.Nil(t, cfg.Endpoints[1].EgressProxy)
+	})
+
+	t.Run("it should inherit the body size limits of the gateway", func(t *testing.T) {
+		cfg := &config.Config{
+			Gateway: config.GatewayConfig{MaxBodyBytes: 1024, MaxResponseBytes: 4096},
+			Endpoints: []config.EndpointConfig{
+				{Path: "/inherited"},
+				{Path: "/overridden", MaxBodyBytes: 10, MaxResponseBytes: 20},
+				{Path: "/unlimited", MaxBodyBytes: -1},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		require.Equal(t, int64(1024), cfg.Endpoints[0].MaxBodyBytes)
+		require.Equal(t, int64(4096), cfg.Endpoints[0].MaxResponseBytes)
+		require.Equal(t, int64(10), cfg.Endpoints[1].MaxBodyBytes)
+		require.Equal(t, int64(20), cfg.Endpoints[1].MaxResponseBytes)
+		require.Equal(t, int64(-1), cfg.Endpoints[2].MaxBodyBytes)
 	})
 
 	t.Run("it should not override existing values", func(t *testing.T) {
@@ -90,3 +241,35 @@ func TestConfig_WithDefaults(t *testing.T) {
 		require.Equal(t, 20*time.Second, cfg.Gateway.ShutdownTimeout)
 	})
 }
+
+func TestConfig_WithDefaults_HeaderPolicies(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should merge gateway header policies into endpoints", func(t *testing.T) {
+		cfg := &config.Config{
+			Gateway: config.GatewayConfig{
+				RequestHeaders: config.HeaderPolicyConfig{
+					Deny: []string{"Cookie"},
+					Set:  map[string]string{"X-Gateway": "heimdall", "X-Env": "prod"},
+				},
+				ResponseHeaders: config.HeaderPolicyConfig{Remove: []string{"Server"}},
+			},
+			Endpoints: []config.EndpointConfig{
+				{
+					RequestHeaders: config.HeaderPolicyConfig{
+						Allow: []string{"X-Tenant-*"},
+						Set:   map[string]string{"x-env": "staging"},
+					},
+				},
+			},
+		}
+
+		cfg = cfg.WithDefaults()
+
+		endpoint := cfg.Endpoints[0]
+		require.Equal(t, []string{"X-Tenant-*"}, endpoint.RequestHeaders.Allow)
+		require.Equal(t, []string{"Cookie"}, endpoint.RequestHeaders.Deny)
+		require.Equal(t, map[string]string{"X-Gateway": "heimdall", "X-Env": "staging"}, endpoint.RequestHeaders.Set)
+		require.Equal(t, []string{"Server"}, endpoint.ResponseHeaders.Remove)
+	})
+}
@@ -0,0 +1,413 @@
+// Package fastcgi implements a FastCGI client exposed as an http.RoundTripper.
+package fastcgi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record types and roles, see https://fastcgi-archives.github.io/FastCGI_Specification.html
+const (
+	typeBeginRequest = 1
+	typeEndRequest   = 3
+	typeParams       = 4
+	typeStdin        = 5
+	typeStdout       = 6
+	typeStderr       = 7
+
+	roleResponder = 1
+
+	// requestID is the identifier of the single request sent on each connection.
+	requestID = 1
+
+	maxRecordContent = 65535
+
+	// DefaultMaxBufferedBody is the size of the chunked request bodies buffered by default
+	DefaultMaxBufferedBody = 10 << 20
+)
+
+var errShortHeader = errors.New("fastcgi: malformed response header")
+
+// Transport sends HTTP requests to a FastCGI responder, such as PHP-FPM.
+// A new connection is opened for every request.
+type Transport struct {
+	// Network and Address of the FastCGI server, e.g. "tcp" and "127.0.0.1:9000", or "unix" and a socket path
+	Network string
+	Address string
+	// ScriptFilename is the script executed by the responder (SCRIPT_FILENAME)
+	ScriptFilename string
+	// Root is the URL path prefix the script is mounted on (SCRIPT_NAME), the rest of the path is PATH_INFO
+	Root string
+	// DialTimeout limits the time spent connecting to the server
+	DialTimeout time.Duration
+	// MaxBufferedBody bounds the request bodies of unknown length buffered to announce their
+	// CONTENT_LENGTH, larger ones are rejected with 411 Length Required. Defaults to DefaultMaxBufferedBody.
+	MaxBufferedBody int64
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Responders read CONTENT_LENGTH bytes of the standard input, so chunked bodies are buffered to count them.
+	if req.ContentLength < 0 && req.Body != nil && req.Body != http.NoBody {
+		buffered, err := t.bufferBody(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if buffered == nil {
+			return lengthRequired(req), nil
+		}
+
+		req = buffered
+	}
+
+	dialer := &net.Dialer{Timeout: t.DialTimeout}
+	conn, err := dialer.DialContext(req.Context(), t.Network, t.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	// Closing the connection unblocks every pending read and write when the request is canceled.
+	stop := context.AfterFunc(req.Context(), func() { conn.Close() }) //nolint:errcheck
+
+	// The request body is sent concurrently, as responders may write before reading all of it.
+	go func() {
+		if err := t.writeRequest(conn, req); err != nil {
+			conn.Close() //nolint:errcheck
+		}
+	}()
+
+	stdout, pw := io.Pipe()
+	go readResponse(conn, pw)
+
+	body := &responseBody{ReadCloser: stdout, close: func() {
+		stop()
+		conn.Close() //nolint:errcheck
+	}}
+
+	resp, err := parseResponse(req, body)
+	if err != nil {
+		body.Close() //nolint:errcheck
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// bufferBody returns a copy of req with its body in memory, or nil if the body is too large
+func (t *Transport) bufferBody(req *http.Request) (*http.Request, error) {
+	limit := t.MaxBufferedBody
+	if limit <= 0 {
+		limit = DefaultMaxBufferedBody
+	}
+
+	defer req.Body.Close() //nolint:errcheck
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(body)) > limit {
+		return nil, nil
+	}
+
+	buffered := req.WithContext(req.Context())
+	buffered.Body = io.NopCloser(bytes.NewReader(body))
+	buffered.ContentLength = int64(len(body))
+
+	return buffered, nil
+}
+
+// lengthRequired returns the 411 response of a request whose body is too large to be buffered
+func lengthRequired(req *http.Request) *http.Response {
+	body := http.StatusText(http.StatusLengthRequired)
+
+	return &http.Response{
+		Status:        strconv.Itoa(http.StatusLengthRequired) + " " + body,
+		StatusCode:    http.StatusLengthRequired,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func (t *Transport) writeRequest(conn net.Conn, req *http.Request) error {
+	w := bufio.NewWriter(conn)
+
+	begin := []byte{0, roleResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(w, typeBeginRequest, begin); err != nil {
+		return err
+	}
+
+	if err := writeStream(w, typeParams, encodeParams(t.params(req))); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if req.Body != nil {
+		if _, err := io.Copy(&streamWriter{w: w, recordType: typeStdin}, req.Body); err != nil {
+			return err
+		}
+	}
+
+	if err := writeRecord(w, typeStdin, nil); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// params returns the CGI/1.1 meta-variables of the request (RFC 3875).
+func (t *Transport) params(req *http.Request) map[string]string {
+	root := strings.TrimRight(t.Root, "/")
+	pathInfo := strings.TrimPrefix(req.URL.Path, root)
+
+	params := map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_SOFTWARE":   "Heimdall",
+		"SERVER_PROTOCOL":   req.Proto,
+		"REQUEST_METHOD":    req.Method,
+		"REQUEST_URI":       req.URL.RequestURI(),
+		"QUERY_STRING":      req.URL.RawQuery,
+		"SCRIPT_FILENAME":   t.ScriptFilename,
+		"SCRIPT_NAME":       root,
+		"PATH_INFO":         pathInfo,
+		"HTTP_HOST":         req.Host,
+	}
+
+	if host, port, err := net.SplitHostPort(req.Host); err == nil {
+		params["SERVER_NAME"], params["SERVER_PORT"] = host, port
+	} else {
+		params["SERVER_NAME"], params["SERVER_PORT"] = req.Host, "80"
+	}
+
+	// The port the request was received on, when known, is the one of the server.
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			params["SERVER_PORT"] = port
+		}
+	}
+
+	if host, port, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		params["REMOTE_ADDR"], params["REMOTE_PORT"] = host, port
+	}
+
+	if req.TLS != nil {
+		params["HTTPS"] = "on"
+	}
+
+	if req.ContentLength > 0 {
+		params["CONTENT_LENGTH"] = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		params["CONTENT_TYPE"] = contentType
+	}
+
+	for header, values := range req.Header {
+		// Proxy is skipped to protect scripts from httpoxy (CVE-2016-5385).
+		if header == "Content-Type" || header == "Content-Length" || header == "Proxy" {
+			continue
+		}
+
+		name := "HTTP_" + strings.ToUpper(strings.ReplaceAll(header, "-", "_"))
+		params[name] = strings.Join(values, ", ")
+	}
+
+	return params
+}
+
+// readResponse copies the standard output of the responder to w until the end of the request.
+func readResponse(conn net.Conn, w *io.PipeWriter) {
+	r := bufio.NewReader(conn)
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			w.CloseWithError(fmt.Errorf("fastcgi: reading record: %w", err)) //nolint:errcheck
+			return
+		}
+
+		recordType := header[1]
+		content := make([]byte, binary.BigEndian.Uint16(header[4:6]))
+		if _, err := io.ReadFull(r, content); err != nil {
+			w.CloseWithError(fmt.Errorf("fastcgi: reading record: %w", err)) //nolint:errcheck
+			return
+		}
+
+		if _, err := r.Discard(int(header[6])); err != nil {
+			w.CloseWithError(fmt.Errorf("fastcgi: reading record: %w", err)) //nolint:errcheck
+			return
+		}
+
+		switch recordType {
+		case typeStdout:
+			if _, err := w.Write(content); err != nil {
+				return
+			}
+		case typeStderr:
+			if len(content) > 0 {
+				slog.Warn("fastcgi stderr", "message", strings.TrimSpace(string(content)))
+			}
+		case typeEndRequest:
+			w.Close() //nolint:errcheck
+			return
+		}
+	}
+}
+
+// parseResponse reads the CGI response header and returns the response streaming the rest of the body.
+func parseResponse(req *http.Request, body *responseBody) (*http.Response, error) {
+	r := bufio.NewReader(body.ReadCloser)
+	mimeHeader, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errShortHeader
+		}
+
+		return nil, err
+	}
+
+	header := http.Header(mimeHeader)
+	statusCode := http.StatusOK
+	if status := header.Get("Status"); status != "" {
+		code, _, _ := strings.Cut(status, " ")
+		statusCode, err = strconv.Atoi(code)
+		if err != nil || statusCode < 100 || statusCode > 999 {
+			return nil, fmt.Errorf("fastcgi: invalid status %q", status)
+		}
+
+		header.Del("Status")
+	} else if header.Get("Location") != "" {
+		statusCode = http.StatusFound
+	}
+
+	contentLength := int64(-1)
+	if value := header.Get("Content-Length"); value != "" {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			contentLength = n
+		}
+	}
+
+	body.ReadCloser = struct {
+		io.Reader
+		io.Closer
+	}{r, body.ReadCloser}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: contentLength,
+		Request:       req,
+	}, nil
+}
+
+// responseBody closes the connection to the responder once the response is consumed.
+type responseBody struct {
+	io.ReadCloser
+	once  sync.Once
+	close func()
+}
+
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.close)
+	return err
+}
+
+func writeRecord(w io.Writer, recordType byte, content []byte) error {
+	padding := -len(content) & 7
+	header := []byte{1, recordType, 0, requestID, 0, 0, byte(padding), 0}
+	binary.BigEndian.PutUint16(header[4:6], uint16(len(content)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+
+	_, err := w.Write(make([]byte, padding))
+	return err
+}
+
+// writeStream writes content as a stream of records, terminated by an empty record.
+func writeStream(w io.Writer, recordType byte, content []byte) error {
+	if _, err := (&streamWriter{w: w, recordType: recordType}).Write(content); err != nil {
+		return err
+	}
+
+	return writeRecord(w, recordType, nil)
+}
+
+// streamWriter splits writes into records of the stream.
+type streamWriter struct {
+	w          io.Writer
+	recordType byte
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), maxRecordContent)
+		if err := writeRecord(s.w, s.recordType, p[:n]); err != nil {
+			return written, err
+		}
+
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func encodeParams(params map[string]string) []byte {
+	var buf []byte
+	for name, value := range params {
+		buf = appendLength(buf, len(name))
+		buf = appendLength(buf, len(value))
+		buf = append(buf, name...)
+		buf = append(buf, value...)
+	}
+
+	return buf
+}
+
+func appendLength(buf []byte, n int) []byte {
+	if n < 128 {
+		return append(buf, byte(n))
+	}
+
+	return binary.BigEndian.AppendUint32(buf, uint32(n)|1<<31)
+}
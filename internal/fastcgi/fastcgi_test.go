@@ -0,0 +1,143 @@
+package fastcgi_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/fastcgi"
+	"github.com/stretchr/testify/require"
+)
+
+func serveFastCGI(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() }) //nolint:errcheck
+
+	go fcgi.Serve(listener, handler) //nolint:errcheck
+
+	return listener.Addr().String()
+}
+
+func TestTransport_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should send the request to the responder", func(t *testing.T) {
+		address := serveFastCGI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			env := fcgi.ProcessEnv(r)
+			body, _ := io.ReadAll(r.Body)
+
+			w.Header().Set("X-Script", env["SCRIPT_FILENAME"])
+			w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body))) //nolint:errcheck
+		}))
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/var/www/index.php", Root: "/app"}
+
+		req := httptest.NewRequest(http.MethodPost, "http://localhost/app/users?page=2", strings.NewReader("payload"))
+		req.Header.Set("X-Tenant", "acme")
+
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.Equal(t, "/var/www/index.php", resp.Header.Get("X-Script"))
+		require.Equal(t, "acme", resp.Header.Get("X-Tenant"))
+		require.Equal(t, "POST /app/users?page=2 payload", string(body))
+	})
+
+	t.Run("it should stream large bodies in both directions", func(t *testing.T) {
+		address := serveFastCGI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(w, r.Body) //nolint:errcheck
+		}))
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/echo.php"}
+
+		payload := strings.Repeat("heimdall", 64*1024)
+		resp, err := transport.RoundTrip(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload)))
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, payload, string(body))
+	})
+
+	t.Run("it should announce the length of chunked bodies", func(t *testing.T) {
+		address := serveFastCGI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write([]byte(strconv.FormatInt(r.ContentLength, 10) + " " + string(body))) //nolint:errcheck
+		}))
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/form.php"}
+
+		req := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("pay"), strings.NewReader("load")))
+		req.ContentLength = -1
+
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "7 payload", string(body))
+	})
+
+	t.Run("it should reject chunked bodies too large to be buffered", func(t *testing.T) {
+		address := serveFastCGI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("the responder should not be called")
+		}))
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/form.php", MaxBufferedBody: 4}
+
+		req := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(strings.NewReader("payload")))
+		req.ContentLength = -1
+
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusLengthRequired, resp.StatusCode)
+	})
+
+	t.Run("it should return an error if the responder is unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close() //nolint:errcheck
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/index.php"}
+
+		_, err = transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.Error(t, err)
+	})
+
+	t.Run("it should return the context error when the request is canceled", func(t *testing.T) {
+		address := serveFastCGI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+
+		transport := &fastcgi.Transport{Network: "tcp", Address: address, ScriptFilename: "/slow.php"}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
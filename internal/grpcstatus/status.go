@@ -0,0 +1,136 @@
+// Package grpcstatus maps gateway errors to gRPC status codes.
+package grpcstatus
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	OK                 = 0
+	Canceled           = 1
+	Unknown            = 2
+	InvalidArgument    = 3
+	DeadlineExceeded   = 4
+	NotFound           = 5
+	AlreadyExists      = 6
+	PermissionDenied   = 7
+	ResourceExhausted  = 8
+	FailedPrecondition = 9
+	Aborted            = 10
+	OutOfRange         = 11
+	Unimplemented      = 12
+	Internal           = 13
+	Unavailable        = 14
+	DataLoss           = 15
+	Unauthenticated    = 16
+)
+
+var names = map[int]string{
+	OK:                 "OK",
+	Canceled:           "CANCELLED",
+	Unknown:            "UNKNOWN",
+	InvalidArgument:    "INVALID_ARGUMENT",
+	DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	NotFound:           "NOT_FOUND",
+	AlreadyExists:      "ALREADY_EXISTS",
+	PermissionDenied:   "PERMISSION_DENIED",
+	ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	FailedPrecondition: "FAILED_PRECONDITION",
+	Aborted:            "ABORTED",
+	OutOfRange:         "OUT_OF_RANGE",
+	Unimplemented:      "UNIMPLEMENTED",
+	Internal:           "INTERNAL",
+	Unavailable:        "UNAVAILABLE",
+	DataLoss:           "DATA_LOSS",
+	Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// Name returns the canonical name of a gRPC status code
+func Name(code int) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+
+	return "CODE(" + strconv.Itoa(code) + ")"
+}
+
+// FromHTTPStatus maps an HTTP status generated by the gateway to a gRPC status code
+func FromHTTPStatus(status int) int {
+	switch status {
+	case http.StatusOK:
+		return OK
+	case http.StatusBadRequest:
+		return Internal
+	case http.StatusUnauthorized:
+		return Unauthenticated
+	case http.StatusForbidden:
+		return PermissionDenied
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return Unimplemented
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return ResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return DeadlineExceeded
+	default:
+		return Unknown
+	}
+}
+
+// FromHeader returns the gRPC status found in the headers or trailers of a response, if any
+func FromHeader(h http.Header) (int, bool) {
+	value := h.Get("Grpc-Status")
+	if value == "" {
+		value = h.Get(http.TrailerPrefix + "Grpc-Status")
+	}
+
+	if value == "" {
+		return 0, false
+	}
+
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return code, true
+}
+
+// IsGRPCRequest reports whether the request is a native gRPC request
+func IsGRPCRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+// IsGRPCWebRequest reports whether the request is a gRPC-Web request, binary or text
+func IsGRPCWebRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, "application/grpc-web")
+}
+
+// WriteWebError writes a headers-only gRPC-Web response carrying the status code and message
+func WriteWebError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc-web+proto")
+	writeStatus(w, code, message)
+}
+
+// WriteError writes a trailers-only gRPC response carrying the status code and message
+func WriteError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	writeStatus(w, code, message)
+}
+
+func writeStatus(w http.ResponseWriter, code int, message string) {
+	header := w.Header()
+	header.Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		header.Set("Grpc-Message", message)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,72 @@
+package grpcstatus_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/grpcstatus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestName(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should return the canonical name of a code", func(t *testing.T) {
+		require.Equal(t, "UNAVAILABLE", grpcstatus.Name(grpcstatus.Unavailable))
+		require.Equal(t, "CODE(42)", grpcstatus.Name(42))
+	})
+}
+
+func TestFromHTTPStatus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should map gateway statuses to gRPC codes", func(t *testing.T) {
+		require.Equal(t, grpcstatus.Unimplemented, grpcstatus.FromHTTPStatus(http.StatusNotFound))
+		require.Equal(t, grpcstatus.ResourceExhausted, grpcstatus.FromHTTPStatus(http.StatusRequestEntityTooLarge))
+		require.Equal(t, grpcstatus.Unavailable, grpcstatus.FromHTTPStatus(http.StatusBadGateway))
+		require.Equal(t, grpcstatus.DeadlineExceeded, grpcstatus.FromHTTPStatus(http.StatusGatewayTimeout))
+		require.Equal(t, grpcstatus.Unknown, grpcstatus.FromHTTPStatus(http.StatusTeapot))
+	})
+}
+
+func TestFromHeader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should read the status from headers or trailers", func(t *testing.T) {
+		code, ok := grpcstatus.FromHeader(http.Header{"Grpc-Status": {"5"}})
+		require.True(t, ok)
+		require.Equal(t, grpcstatus.NotFound, code)
+
+		code, ok = grpcstatus.FromHeader(http.Header{http.TrailerPrefix + "Grpc-Status": {"0"}})
+		require.True(t, ok)
+		require.Equal(t, grpcstatus.OK, code)
+
+		_, ok = grpcstatus.FromHeader(http.Header{})
+		require.False(t, ok)
+	})
+}
+
+func TestWriteError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should write a trailers-only response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+
+		grpcstatus.WriteError(rec, grpcstatus.Unavailable, "Gateway error")
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "application/grpc", rec.Header().Get("Content-Type"))
+		require.Equal(t, "14", rec.Header().Get("Grpc-Status"))
+		require.Equal(t, "Gateway error", rec.Header().Get("Grpc-Message"))
+	})
+
+	t.Run("it should detect gRPC requests", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/pkg.Service/Method", nil)
+		req.Header.Set("Content-Type", "application/grpc+proto")
+		require.True(t, grpcstatus.IsGRPCRequest(req))
+
+		req.Header.Set("Content-Type", "application/json")
+		require.False(t, grpcstatus.IsGRPCRequest(req))
+	})
+}
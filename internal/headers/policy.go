@@ -0,0 +1,155 @@
+// Package headers implements the header policies applied to proxied requests and responses.
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// Policy is a compiled header policy. A nil Policy allows nothing and applies no action.
+type Policy struct {
+	allow  []string
+	deny   []string
+	remove []string
+	rename map[string]string
+	set    map[string]string
+	add    map[string][]string
+}
+
+// NewPolicy compiles a header policy configuration. It returns nil when the configuration is empty.
+func NewPolicy(cfg config.HeaderPolicyConfig) (*Policy, error) {
+	if cfg.IsZero() {
+		return nil, nil
+	}
+
+	allow, err := compilePatterns(cfg.Allow)
+	if err != nil {
+		return nil, err
+	}
+
+	deny, err := compilePatterns(cfg.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	remove, err := compilePatterns(cfg.Remove)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		allow:  allow,
+		deny:   deny,
+		remove: remove,
+		rename: make(map[string]string, len(cfg.Rename)),
+		set:    make(map[string]string, len(cfg.Set)),
+		add:    make(map[string][]string, len(cfg.Add)),
+	}
+
+	for from, to := range cfg.Rename {
+		policy.rename[http.CanonicalHeaderKey(from)] = http.CanonicalHeaderKey(to)
+	}
+
+	for name, value := range cfg.Set {
+		policy.set[http.CanonicalHeaderKey(name)] = value
+	}
+
+	for name, values := range cfg.Add {
+		policy.add[http.CanonicalHeaderKey(name)] = values
+	}
+
+	return policy, nil
+}
+
+// compilePatterns lower-cases and validates glob patterns
+func compilePatterns(patterns []string) ([]string, error) {
+	compiled := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid header pattern %q: %w", pattern, err)
+		}
+
+		compiled = append(compiled, pattern)
+	}
+
+	return compiled, nil
+}
+
+// matchAny reports whether the header name matches one of the patterns
+func matchAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows reports whether the header name is explicitly matched by an allow pattern
+func (p *Policy) Allows(name string) bool {
+	return p != nil && matchAny(p.allow, name)
+}
+
+// Denies reports whether the header name is matched by a deny pattern
+func (p *Policy) Denies(name string) bool {
+	return p != nil && matchAny(p.deny, name)
+}
+
+// Permits reports whether a header survives filtering, that is whether it is not denied.
+// Allow patterns add headers to the forwarded ones and never restrict them.
+func (p *Policy) Permits(name string) bool {
+	return !p.Denies(name)
+}
+
+// Filter removes the headers denied by the policy
+func (p *Policy) Filter(h http.Header) {
+	if p == nil {
+		return
+	}
+
+	for name := range h {
+		if !p.Permits(name) {
+			delete(h, name)
+		}
+	}
+}
+
+// Apply runs the policy actions on the headers, in order: rename, remove, set and add.
+func (p *Policy) Apply(h http.Header) {
+	if p == nil {
+		return
+	}
+
+	for from, to := range p.rename {
+		values, ok := h[from]
+		if !ok {
+			continue
+		}
+
+		delete(h, from)
+		h[to] = append(h[to], values...)
+	}
+
+	for name := range h {
+		if matchAny(p.remove, name) {
+			delete(h, name)
+		}
+	}
+
+	for name, value := range p.set {
+		h.Set(name, value)
+	}
+
+	for name, values := range p.add {
+		for _, value := range values {
+			h.Add(name, value)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package headers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should return nil for an empty configuration", func(t *testing.T) {
+		policy, err := headers.NewPolicy(config.HeaderPolicyConfig{})
+		require.NoError(t, err)
+		require.Nil(t, policy)
+	})
+
+	t.Run("it should return an error for an invalid pattern", func(t *testing.T) {
+		_, err := headers.NewPolicy(config.HeaderPolicyConfig{Allow: []string{"X-["}})
+		require.Error(t, err)
+	})
+}
+
+func TestPolicy_Filter(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should match patterns case-insensitively", func(t *testing.T) {
+		policy, err := headers.NewPolicy(config.HeaderPolicyConfig{
+			Allow: []string{"x-tenant-*"},
+			Deny:  []string{"X-Tenant-Secret"},
+		})
+		require.NoError(t, err)
+
+		require.True(t, policy.Allows("X-Tenant-Id"))
+		require.True(t, policy.Denies("x-tenant-secret"))
+		require.False(t, policy.Permits("X-Tenant-Secret"))
+		require.True(t, policy.Permits("X-Tenant-Id"))
+		// Allow patterns add headers, they do not restrict the others.
+		require.True(t, policy.Permits("Cookie"))
+	})
+
+	t.Run("it should remove headers that are not permitted", func(t *testing.T) {
+		policy, err := headers.NewPolicy(config.HeaderPolicyConfig{Deny: []string{"Server", "X-Powered-*"}})
+		require.NoError(t, err)
+
+		h := http.Header{}
+		h.Set("Server", "nginx")
+		h.Set("X-Powered-By", "PHP")
+		h.Set("Content-Type", "application/json")
+
+		policy.Filter(h)
+
+		require.Empty(t, h.Get("Server"))
+		require.Empty(t, h.Get("X-Powered-By"))
+		require.Equal(t, "application/json", h.Get("Content-Type"))
+	})
+
+	t.Run("it should be a no-op on a nil policy", func(t *testing.T) {
+		var policy *headers.Policy
+
+		h := http.Header{"Server": []string{"nginx"}}
+		policy.Filter(h)
+		policy.Apply(h)
+
+		require.Equal(t, "nginx", h.Get("Server"))
+		require.False(t, policy.Allows("Server"))
+		require.True(t, policy.Permits("Server"))
+	})
+}
+
+func TestPolicy_Apply(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should rename, remove, set and add headers", func(t *testing.T) {
+		policy, err := headers.NewPolicy(config.HeaderPolicyConfig{
+			Rename: map[string]string{"x-old": "X-New"},
+			Remove: []string{"Cookie"},
+			Set:    map[string]string{"X-Set": "set"},
+			Add:    map[string][]string{"X-Add": {"a", "b"}},
+		})
+		require.NoError(t, err)
+
+		h := http.Header{}
+		h.Set("X-Old", "value")
+		h.Set("Cookie", "session=1")
+		h.Set("X-Set", "original")
+
+		policy.Apply(h)
+
+		require.Empty(t, h.Get("X-Old"))
+		require.Equal(t, "value", h.Get("X-New"))
+		require.Empty(t, h.Get("Cookie"))
+		require.Equal(t, "set", h.Get("X-Set"))
+		require.Equal(t, []string{"a", "b"}, h.Values("X-Add"))
+	})
+}
@@ -0,0 +1,30 @@
+package headers
+
+import (
+	"context"
+	"net/http"
+)
+
+type upstreamContextKey struct{}
+
+// WithUpstream returns a copy of ctx carrying headers to send upstream, such as the identity
+// authenticated by a middleware. They are set after the filtering of the route, replacing the
+// values sent by the client.
+func WithUpstream(ctx context.Context, header http.Header) context.Context {
+	merged := make(http.Header)
+	for name, values := range UpstreamFromContext(ctx) {
+		merged[name] = values
+	}
+
+	for name, values := range header {
+		merged[http.CanonicalHeaderKey(name)] = values
+	}
+
+	return context.WithValue(ctx, upstreamContextKey{}, merged)
+}
+
+// UpstreamFromContext returns the headers to send upstream carried by ctx, if any
+func UpstreamFromContext(ctx context.Context) http.Header {
+	header, _ := ctx.Value(upstreamContextKey{}).(http.Header)
+	return header
+}
@@ -0,0 +1,179 @@
+// Package problem renders the errors generated by the gateway as RFC 7807 problem details.
+package problem
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	texttemplate "text/template"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// ContentType is the media type of problem details
+const ContentType = "application/problem+json"
+
+// RequestIDHeader carries the request ID, set by a middleware or generated on error
+const RequestIDHeader = "X-Request-Id"
+
+// Stable error codes of the errors generated by the gateway
+const (
+	CodeRouteNotFound        = "route_not_found"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeBodyTooLarge         = "request_body_too_large"
+	CodeUpstreamError        = "upstream_error"
+	CodeInvalidResponse      = "invalid_upstream_response"
+	CodeNoUpstream           = "no_upstream_available"
+	CodeHandlerNotRegistered = "handler_not_registered"
+	CodeAggregatePartFailed  = "aggregate_part_failed"
+	CodeShuttingDown         = "gateway_shutting_down"
+)
+
+// Problem is an error generated by the gateway
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// New returns the problem of a status with a stable code and a human-readable detail.
+func New(status int, code, detail string) Problem {
+	return Problem{
+		Type:   "urn:heimdall:error:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Renderer writes problems with the templates configured for their status,
+// as problem+json otherwise. A nil Renderer always writes problem+json.
+type Renderer struct {
+	templates map[int]*template
+}
+
+type template struct {
+	contentType string
+	executor    interface {
+		Execute(w io.Writer, data any) error
+	}
+}
+
+// NewRenderer compiles the templates of the configuration, and returns nil if there is none.
+func NewRenderer(cfg config.ErrorsConfig) (*Renderer, error) {
+	if len(cfg.Templates) == 0 {
+		return nil, nil
+	}
+
+	r := &Renderer{templates: make(map[int]*template, len(cfg.Templates))}
+	for status, templateConfig := range cfg.Templates {
+		t, err := newTemplate(status, templateConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		r.templates[status] = t
+	}
+
+	return r, nil
+}
+
+func newTemplate(status int, cfg config.ErrorTemplateConfig) (*template, error) {
+	body := cfg.Body
+	if cfg.File != "" {
+		content, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read error template for status %d: %w", status, err)
+		}
+
+		body = string(content)
+	}
+
+	contentType := cfg.ContentType
+	if contentType == "" {
+		contentType = ContentType
+	}
+
+	name := strconv.Itoa(status)
+	t := &template{contentType: contentType}
+
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/html" {
+		t.executor, err = htmltemplate.New(name).Parse(body)
+	} else {
+		t.executor, err = texttemplate.New(name).Parse(body)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid error template for status %d: %w", status, err)
+	}
+
+	return t, nil
+}
+
+// Render writes the problem in response to req.
+func (r *Renderer) Render(w http.ResponseWriter, req *http.Request, p Problem) {
+	p.Instance = req.URL.Path
+	p.RequestID = requestID(w, req)
+
+	contentType, body := ContentType, []byte(nil)
+	if t, ok := r.template(p.Status); ok {
+		var buf bytes.Buffer
+		if err := t.executor.Execute(&buf, p); err != nil {
+			slog.ErrorContext(req.Context(), "failed to render error template", "status", p.Status, "error", err)
+		} else {
+			contentType, body = t.contentType, buf.Bytes()
+		}
+	}
+
+	if body == nil {
+		body, _ = json.Marshal(p)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	w.Write(body) //nolint:errcheck
+}
+
+func (r *Renderer) template(status int) (*template, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	t, ok := r.templates[status]
+	return t, ok
+}
+
+// requestID returns the ID of the request, generating one if no middleware did.
+func requestID(w http.ResponseWriter, req *http.Request) string {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	buf := make([]byte, 8)
+	rand.Read(buf) //nolint:errcheck
+	id := hex.EncodeToString(buf)
+	w.Header().Set(RequestIDHeader, id)
+
+	return id
+}
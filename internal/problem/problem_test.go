@@ -0,0 +1,102 @@
+package problem_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderer_Render(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should render problem+json by default", func(t *testing.T) {
+		var renderer *problem.Renderer
+
+		req := httptest.NewRequest(http.MethodGet, "/users?page=2", nil)
+		req.Header.Set("X-Request-Id", "req-42")
+
+		recorder := httptest.NewRecorder()
+		renderer.Render(recorder, req, problem.New(http.StatusBadGateway, problem.CodeUpstreamError, "Gateway error"))
+
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		require.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+
+		var pb problem.Problem
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &pb))
+		require.Equal(t, problem.Problem{
+			Type:      "urn:heimdall:error:upstream_error",
+			Title:     "Bad Gateway",
+			Status:    http.StatusBadGateway,
+			Detail:    "Gateway error",
+			Instance:  "/users",
+			Code:      "upstream_error",
+			RequestID: "req-42",
+		}, pb)
+	})
+
+	t.Run("it should generate a request ID if there is none", func(t *testing.T) {
+		var renderer *problem.Renderer
+
+		recorder := httptest.NewRecorder()
+		renderer.Render(recorder, httptest.NewRequest(http.MethodGet, "/", nil), problem.New(http.StatusNotFound, problem.CodeRouteNotFound, "Route Not Found"))
+
+		var pb problem.Problem
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &pb))
+		require.NotEmpty(t, pb.RequestID)
+		require.Equal(t, pb.RequestID, recorder.Header().Get("X-Request-Id"))
+	})
+
+	t.Run("it should render the template of the status", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "404.html")
+		require.NoError(t, os.WriteFile(path, []byte("<h1>{{.Title}}</h1><p>{{.Instance}}</p>"), 0o600))
+
+		renderer, err := problem.NewRenderer(config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{
+			http.StatusNotFound:   {ContentType: "text/html; charset=utf-8", File: path},
+			http.StatusBadGateway: {ContentType: "application/json", Body: `{"error":"{{.Code}}","id":"{{.RequestID}}"}`},
+		}})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/<script>", nil)
+		req.Header.Set("X-Request-Id", "req-42")
+
+		recorder := httptest.NewRecorder()
+		renderer.Render(recorder, req, problem.New(http.StatusNotFound, problem.CodeRouteNotFound, "Route Not Found"))
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.Equal(t, "text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "<h1>Not Found</h1><p>/&lt;script&gt;</p>", recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		renderer.Render(recorder, req, problem.New(http.StatusBadGateway, problem.CodeUpstreamError, "Gateway error"))
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		require.Equal(t, `{"error":"upstream_error","id":"req-42"}`, recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		renderer.Render(recorder, req, problem.New(http.StatusServiceUnavailable, problem.CodeShuttingDown, "Gateway is shutting down"))
+		require.Equal(t, "application/problem+json", recorder.Header().Get("Content-Type"))
+	})
+}
+
+func TestNewRenderer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should return nil without templates", func(t *testing.T) {
+		renderer, err := problem.NewRenderer(config.ErrorsConfig{})
+		require.NoError(t, err)
+		require.Nil(t, renderer)
+	})
+
+	t.Run("it should return an error for invalid templates", func(t *testing.T) {
+		_, err := problem.NewRenderer(config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{404: {Body: "{{.Title"}}})
+		require.Error(t, err)
+
+		_, err = problem.NewRenderer(config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{404: {File: "nonexistent.html"}}})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// pathValuePlaceholder matches the {param} placeholders of targets
+var pathValuePlaceholder = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+// expandTarget returns a copy of target whose path and query placeholders are replaced
+// by the path values of req.
+func expandTarget(target *url.URL, req *http.Request) *url.URL {
+	u := *target
+	if !strings.Contains(u.Path, "{") && !strings.Contains(u.RawQuery, "{") {
+		return &u
+	}
+
+	expand := func(s string, escape func(string) string) string {
+		return pathValuePlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+			return escape(req.PathValue(placeholder[1 : len(placeholder)-1]))
+		})
+	}
+
+	u.RawPath = expand(u.Path, url.PathEscape)
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = expand(u.RawQuery, url.QueryEscape)
+
+	return &u
+}
+
+// serveAggregate sends the parts of an aggregation route concurrently and merges their
+// JSON responses under the name of each part.
+func (p *Handler) serveAggregate(w http.ResponseWriter, req *http.Request, route *router.Route) {
+	aggregate := route.Aggregate
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+
+	results := make([]json.RawMessage, len(aggregate.Parts))
+	errs := make([]error, len(aggregate.Parts))
+	failed := -1
+	var failedOnce sync.Once
+
+	var wg sync.WaitGroup
+	for i, part := range aggregate.Parts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			results[i], errs[i] = p.fetchPart(ctx, req, route, part)
+			if errs[i] != nil {
+				// The first failure is the one reported, the other parts are canceled when it fails the request.
+				failedOnce.Do(func() {
+					failed = i
+					if aggregate.OnError == config.AggregateOnErrorFail {
+						cancel()
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if errors.Is(req.Context().Err(), context.Canceled) {
+		w.Header().Set("Connection", "close")
+		p.writeError(w, req, route, problem.New(http.StatusServiceUnavailable, problem.CodeShuttingDown, "Gateway is shutting down"))
+		return
+	}
+
+	if failed >= 0 && aggregate.OnError == config.AggregateOnErrorFail {
+		name := aggregate.Parts[failed].Name
+		slog.WarnContext(req.Context(), "aggregate part failed", "path", route.OriginalPath, "part", name, "error", errs[failed])
+		p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeAggregatePartFailed, fmt.Sprintf("Part %s failed", name)))
+		return
+	}
+
+	var body bytes.Buffer
+	body.WriteByte('{')
+	for i, part := range aggregate.Parts {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+
+		name, _ := json.Marshal(part.Name)
+		body.Write(name)
+		body.WriteByte(':')
+
+		if errs[i] != nil {
+			slog.WarnContext(req.Context(), "aggregate part failed", "path", route.OriginalPath, "part", part.Name, "error", errs[i])
+			body.WriteString("null")
+			continue
+		}
+
+		body.Write(results[i])
+	}
+	body.WriteByte('}')
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes()) //nolint:errcheck
+}
+
+// addForwardedFor appends the client IP to the X-Forwarded-For header of a part, as the
+// reverse proxy does for the forwarded requests.
+func addForwardedFor(outreq *http.Request, remoteAddr string) {
+	clientIP, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return
+	}
+
+	prior, ok := outreq.Header["X-Forwarded-For"]
+	if ok && prior == nil {
+		return
+	}
+
+	if len(prior) > 0 {
+		clientIP = strings.Join(prior, ", ") + ", " + clientIP
+	}
+	outreq.Header.Set("X-Forwarded-For", clientIP)
+}
+
+// fetchPart sends the sub-request of a part with the headers forwarded by the route,
+// and returns its JSON response.
+func (p *Handler) fetchPart(ctx context.Context, req *http.Request, route *router.Route, part router.AggregatePart) (json.RawMessage, error) {
+	if part.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, part.Timeout)
+		defer cancel()
+	}
+
+	target := expandTarget(part.Target, req)
+	outreq, err := http.NewRequestWithContext(ctx, part.Method, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parts are sent like the requests forwarded by the route, from the Host of the client.
+	outreq.Host = req.Host
+	outreq.Header = req.Header.Clone()
+	p.direct(outreq, route, target.Host)
+	addForwardedFor(outreq, req.RemoteAddr)
+
+	// Sub-requests have no body, and responses are decoded by the transport.
+	outreq.Header.Del("Content-Length")
+	outreq.Header.Del("Accept-Encoding")
+
+	for key, values := range part.Headers {
+		for _, value := range values {
+			outreq.Header.Add(key, value)
+		}
+	}
+
+	rt := part.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+
+	resp, err := rt.RoundTrip(outreq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	// Parts are subject to the response policy of the route, each one within the size limit.
+	if err := checkResponse(resp, route); err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	reader := io.Reader(resp.Body)
+	if route.MaxResponseBytes > 0 {
+		reader = io.LimitReader(resp.Body, route.MaxResponseBytes+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if route.MaxResponseBytes > 0 && int64(len(body)) > route.MaxResponseBytes {
+		return nil, errResponseTooLarge
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("invalid JSON response")
+	}
+
+	return body, nil
+}
@@ -0,0 +1,170 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_Aggregate(t *testing.T) {
+	t.Parallel()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/42":
+			w.Write([]byte(`{"id":"42","tenant":"` + r.Header.Get("X-Tenant") + `","page":"` + r.URL.Query().Get("page") + `"}`)) //nolint:errcheck
+		case "/orders":
+			w.Write([]byte(`[{"user":"` + r.URL.Query().Get("user") + `"}]`)) //nolint:errcheck
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			w.Write([]byte(`{}`)) //nolint:errcheck
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"size":"` + r.URL.Query().Get("size") + `"}`)) //nolint:errcheck
+		case "/html":
+			w.Write([]byte(`<html></html>`)) //nolint:errcheck
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+
+	newHandler := func(t *testing.T, onError string, parts ...config.AggregatePartConfig) *proxy.Handler {
+		cfg := &config.Config{Endpoints: []config.EndpointConfig{{
+			Path:           "/screens/home/{id}",
+			Method:         http.MethodGet,
+			AllowedHeaders: []string{"X-Tenant"},
+			Aggregate:      &config.AggregateConfig{OnError: onError, Timeout: 100 * time.Millisecond, Parts: parts},
+		}}}
+
+		r, err := router.New(cfg.WithDefaults().Endpoints)
+		require.NoError(t, err)
+
+		return proxy.NewHandler(r)
+	}
+
+	t.Run("it should merge the responses of the parts under their name", func(t *testing.T) {
+		handler := newHandler(t, "",
+			config.AggregatePartConfig{Name: "profile", Target: backend.URL + "/users/{id}?page=home"},
+			config.AggregatePartConfig{Name: "orders", Target: backend.URL + "/orders?user={id}"},
+		)
+
+		req := httptest.NewRequest(http.MethodGet, "/screens/home/42", nil)
+		req.Header.Set("X-Tenant", "acme")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		require.Equal(t, `{"profile":{"id":"42","tenant":"acme","page":"home"},"orders":[{"user":"42"}]}`, recorder.Body.String())
+	})
+
+	t.Run("it should return null for the failed parts with the null policy", func(t *testing.T) {
+		handler := newHandler(t, config.AggregateOnErrorNull,
+			config.AggregatePartConfig{Name: "profile", Target: backend.URL + "/users/{id}"},
+			config.AggregatePartConfig{Name: "broken", Target: backend.URL + "/broken"},
+			config.AggregatePartConfig{Name: "slow", Target: backend.URL + "/slow"},
+			config.AggregatePartConfig{Name: "html", Target: backend.URL + "/html"},
+		)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/screens/home/42", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, `{"profile":{"id":"42","tenant":"","page":""},"broken":null,"slow":null,"html":null}`, recorder.Body.String())
+	})
+
+	t.Run("it should apply the response policy of the route to each part", func(t *testing.T) {
+		r, err := router.New((&config.Config{Endpoints: []config.EndpointConfig{{
+			Path:                "/screens/home/{id}",
+			Method:              http.MethodGet,
+			MaxResponseBytes:    20,
+			AllowedContentTypes: []string{"application/json"},
+			Aggregate: &config.AggregateConfig{OnError: config.AggregateOnErrorNull, Parts: []config.AggregatePartConfig{
+				{Name: "small", Target: backend.URL + "/json?size=s"},
+				{Name: "large", Target: backend.URL + "/json?size=large-enough"},
+				{Name: "text", Target: backend.URL + "/orders?user={id}"},
+			}},
+		}}}).WithDefaults().Endpoints)
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(r).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/screens/home/42", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, `{"small":{"size":"s"},"large":null,"text":null}`, recorder.Body.String())
+	})
+
+	t.Run("it should fail the whole request with the fail policy", func(t *testing.T) {
+		handler := newHandler(t, config.AggregateOnErrorFail,
+			config.AggregatePartConfig{Name: "profile", Target: backend.URL + "/users/{id}"},
+			config.AggregatePartConfig{Name: "slow", Target: backend.URL + "/slow"},
+		)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/screens/home/42", nil))
+
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		requireProblem(t, recorder, problem.CodeAggregatePartFailed, "Part slow failed")
+	})
+}
+
+func TestProxyHandler_PathValues(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should send the parts like the requests forwarded by the route", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"host":"` + r.Host + `","for":"` + r.Header.Get("X-Forwarded-For") + `","user":"` + r.Header.Get("X-User") + `","env":"` + r.Header.Get("X-Env") + `"}`)) //nolint:errcheck
+		}))
+		defer backend.Close()
+
+		r, err := router.New([]config.EndpointConfig{{
+			Path:           "/screens/home",
+			Method:         http.MethodGet,
+			HostOverride:   "api.internal",
+			RequestHeaders: config.HeaderPolicyConfig{Set: map[string]string{"X-Env": "prod"}},
+			Aggregate:      &config.AggregateConfig{Parts: []config.AggregatePartConfig{{Name: "me", Target: backend.URL + "/me"}}},
+		}})
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, "/screens/home", nil)
+		req.RemoteAddr = "203.0.113.7:1234"
+		req = req.WithContext(headers.WithUpstream(req.Context(), http.Header{"X-User": []string{"42"}}))
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(r).ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, `{"me":{"host":"api.internal","for":"203.0.113.7","user":"42","env":"prod"}}`, recorder.Body.String())
+	})
+
+	t.Run("it should replace the path parameters of the target", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.EscapedPath() + "?" + r.URL.RawQuery)) //nolint:errcheck
+		}))
+		defer backend.Close()
+
+		r, err := router.New([]config.EndpointConfig{
+			{Path: "/users/{id}", Target: backend.URL + "/v1/users/{id}?expand={id}", Method: http.MethodGet},
+		})
+		require.NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(r).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/users/a%20b", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "/v1/users/a%20b?expand=a+b", recorder.Body.String())
+	})
+}
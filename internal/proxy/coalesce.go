@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// maxCoalescedBytes bounds the buffered responses of routes without response limit
+const maxCoalescedBytes = 10 << 20
+
+// errCoalescedCallAborted is the error of a shared upstream call aborted while its response was copied
+var errCoalescedCallAborted = errors.New("coalesced upstream call aborted")
+
+// coalescable reports whether the request can share the upstream call of identical requests
+func coalescable(req *http.Request, route *router.Route) bool {
+	if !route.Coalesce || req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return upgradeType(req.Header) == ""
+}
+
+// coalesceKey identifies the requests sharing an upstream call. The headers sent upstream on
+// behalf of the middlewares, such as the authenticated identity, are part of the key.
+func coalesceKey(req *http.Request, route *router.Route) string {
+	var key strings.Builder
+	key.WriteString(req.Method + " " + req.Host + req.URL.RequestURI())
+	for _, name := range route.CoalesceVary {
+		key.WriteString("\x00" + http.CanonicalHeaderKey(name) + "=" + strings.Join(req.Header.Values(name), ","))
+	}
+
+	upstream := headers.UpstreamFromContext(req.Context())
+	for _, name := range slices.Sorted(maps.Keys(upstream)) {
+		key.WriteString("\x01" + name + "=" + strings.Join(upstream[name], ","))
+	}
+
+	return key.String()
+}
+
+// serveCoalesced waits for the response of the upstream call shared with identical requests,
+// starting it if there is none
+func (p *Handler) serveCoalesced(w http.ResponseWriter, req *http.Request, route *router.Route) {
+	key := coalesceKey(req, route)
+
+	// The call outlives the request starting it as long as other requests wait for it.
+	ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	call, leader := p.coalescer.join(key, cancel)
+	if leader {
+		outreq := req.Clone(ctx)
+		outreq.Body, outreq.ContentLength = http.NoBody, 0
+
+		go func() {
+			limit := route.MaxResponseBytes
+			if limit <= 0 {
+				limit = maxCoalescedBytes
+			}
+
+			response := newResponseBuffer(limit)
+			defer func() {
+				// Copy errors abort the handler, so they are recovered to fail the waiters instead.
+				if v := recover(); v != nil {
+					if v != http.ErrAbortHandler {
+						panic(v)
+					}
+
+					if response.err == nil {
+						response.err = errCoalescedCallAborted
+					}
+				}
+
+				if response.err != nil {
+					slog.ErrorContext(ctx, "coalesced upstream call failed", "path", route.OriginalPath, "error", response.err)
+				}
+
+				cancel()
+				p.coalescer.finish(key, call, response)
+			}()
+
+			p.forward(response, outreq, route)
+		}()
+	} else {
+		cancel()
+	}
+
+	select {
+	case <-call.done:
+		switch {
+		case errors.Is(call.response.err, errResponseTooLarge):
+			p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeInvalidResponse, "Upstream response too large"))
+		case call.response.err != nil:
+			p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeUpstreamError, "Gateway error"))
+		default:
+			call.response.writeTo(w)
+		}
+	case <-req.Context().Done():
+		p.coalescer.leave(key, call)
+	}
+}
+
+// coalescer tracks the upstream calls shared by identical requests
+type coalescer struct {
+	mutex sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done     chan struct{}
+	cancel   context.CancelFunc
+	waiters  int
+	response *responseBuffer
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		calls: make(map[string]*coalescedCall),
+	}
+}
+
+// join returns the call in progress for key, or a new call canceled by cancel to start if leader is true
+func (c *coalescer) join(key string, cancel context.CancelFunc) (call *coalescedCall, leader bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if call, ok := c.calls[key]; ok {
+		call.waiters++
+		return call, false
+	}
+
+	call = &coalescedCall{done: make(chan struct{}), cancel: cancel, waiters: 1}
+	c.calls[key] = call
+
+	return call, true
+}
+
+// leave removes a canceled request from the waiters, canceling the call once nobody waits for it
+func (c *coalescer) leave(key string, call *coalescedCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	// Requests arriving from now on start a new call rather than joining a canceled one.
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+
+	call.cancel()
+}
+
+// finish publishes the response of the call to its waiters
+func (c *coalescer) finish(key string, call *coalescedCall, response *responseBuffer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.calls[key] == call {
+		delete(c.calls, key)
+	}
+
+	call.response = response
+	close(call.done)
+}
+
+// responseBuffer records a response to write it to several clients. Bodies exceeding the
+// limit fail the response rather than being buffered without bound.
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	limit  int64
+	err    error
+}
+
+func newResponseBuffer(limit int64) *responseBuffer {
+	return &responseBuffer{header: make(http.Header), limit: limit}
+}
+
+func (b *responseBuffer) Header() http.Header {
+	return b.header
+}
+
+func (b *responseBuffer) WriteHeader(code int) {
+	if b.status == 0 && code >= http.StatusOK {
+		b.status = code
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	if int64(b.body.Len()+len(p)) > b.limit {
+		b.err = errResponseTooLarge
+		return 0, b.err
+	}
+
+	return b.body.Write(p)
+}
+
+// writeTo writes the recorded response, adding its headers to the ones already set as the proxy does
+func (b *responseBuffer) writeTo(w http.ResponseWriter) {
+	h := w.Header()
+	for name, values := range b.header {
+		for _, value := range values {
+			h.Add(name, value)
+		}
+	}
+
+	status := b.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	w.WriteHeader(status)
+	w.Write(b.body.Bytes()) //nolint:errcheck
+}
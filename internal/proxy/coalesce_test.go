@@ -0,0 +1,214 @@
+package proxy_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_Coalesce(t *testing.T) {
+	t.Parallel()
+
+	// newGateway returns a gateway whose /test route coalesces requests to a backend
+	// answering once release is closed
+	newGateway := func(t *testing.T, release <-chan struct{}, canceled chan<- struct{}) (http.Handler, *atomic.Int32) {
+		var calls atomic.Int32
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				if canceled != nil {
+					close(canceled)
+				}
+				return
+			}
+
+			w.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+			w.Write([]byte("shared")) //nolint:errcheck
+		}))
+		t.Cleanup(backend.Close)
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:         targetURL,
+			Method:         http.MethodGet,
+			AllowedHeaders: []string{"X-Tenant"},
+			Coalesce:       true,
+			CoalesceVary:   []string{"X-Tenant"},
+		})
+
+		return proxy.NewHandler(mockRouter), &calls
+	}
+
+	serve := func(gateway http.Handler, ctx context.Context, tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, "/test", nil)
+		req.Header.Set("X-Tenant", tenant)
+
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it should share one upstream call between identical requests", func(t *testing.T) {
+		release := make(chan struct{})
+		gateway, calls := newGateway(t, release, nil)
+
+		var wg sync.WaitGroup
+		recorders := make([]*httptest.ResponseRecorder, 5)
+		for i := range recorders {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorders[i] = serve(gateway, context.Background(), "acme")
+			}()
+		}
+
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.Equal(t, int32(1), calls.Load())
+		for _, rec := range recorders {
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, "shared", rec.Body.String())
+			require.Equal(t, []string{"acme"}, rec.Header().Values("X-Tenant"))
+		}
+	})
+
+	t.Run("it should not share calls between requests with different vary headers", func(t *testing.T) {
+		release := make(chan struct{})
+		close(release)
+		gateway, calls := newGateway(t, release, nil)
+
+		require.Equal(t, "acme", serve(gateway, context.Background(), "acme").Header().Get("X-Tenant"))
+		require.Equal(t, "globex", serve(gateway, context.Background(), "globex").Header().Get("X-Tenant"))
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("it should not share calls between requests sending different headers upstream", func(t *testing.T) {
+		release := make(chan struct{})
+		gateway, calls := newGateway(t, release, nil)
+
+		var wg sync.WaitGroup
+		recorders := make(map[string]*httptest.ResponseRecorder)
+		var mutex sync.Mutex
+		for _, tenant := range []string{"acme", "globex"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				// The middlewares authenticating the client set the tenant sent upstream.
+				ctx := headers.WithUpstream(context.Background(), http.Header{"X-Tenant": {tenant}})
+				rec := serve(gateway, ctx, "spoofed")
+
+				mutex.Lock()
+				recorders[tenant] = rec
+				mutex.Unlock()
+			}()
+		}
+
+		require.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		for tenant, rec := range recorders {
+			require.Equal(t, tenant, rec.Header().Get("X-Tenant"))
+		}
+	})
+
+	t.Run("it should keep the call running when the request that started it is canceled", func(t *testing.T) {
+		release := make(chan struct{})
+		gateway, calls := newGateway(t, release, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		leaderDone := make(chan struct{})
+		go func() {
+			defer close(leaderDone)
+			serve(gateway, ctx, "acme")
+		}()
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+		waiter := make(chan *httptest.ResponseRecorder)
+		go func() {
+			waiter <- serve(gateway, context.Background(), "acme")
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		cancel()
+		<-leaderDone
+		close(release)
+
+		rec := <-waiter
+		require.Equal(t, "shared", rec.Body.String())
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("it should cancel the call once every request is canceled", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		canceled := make(chan struct{})
+		gateway, calls := newGateway(t, release, canceled)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			serve(gateway, ctx, "acme")
+		}()
+		require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+
+		cancel()
+		<-done
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("the upstream call was not canceled")
+		}
+	})
+
+	t.Run("it should fail the requests instead of buffering responses beyond the limit", func(t *testing.T) {
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			chunk := bytes.Repeat([]byte("a"), 1<<20)
+			for range 11 {
+				if _, err := w.Write(chunk); err != nil {
+					return
+				}
+			}
+		}))
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{Target: targetURL, Method: http.MethodGet, Coalesce: true})
+
+		// A real server makes the proxy abort the handler on copy errors.
+		gateway := httptest.NewServer(proxy.NewHandler(mockRouter))
+		defer gateway.Close()
+
+		resp, err := http.Get(gateway.URL + "/test")
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// FallbackHeader marks the responses served by the fallback target of a route
+const FallbackHeader = "X-Heimdall-Fallback"
+
+// maxFallbackBodyBytes bounds the request bodies buffered to be replayed to the fallback,
+// larger requests are not sent to the fallback
+const maxFallbackBodyBytes = 1 << 20
+
+// fallbackTransport sends the request to the fallback of the route when the target fails it
+type fallbackTransport struct {
+	next     http.RoundTripper
+	fallback *router.Fallback
+	// target is the URL of the fallback, with the path values and the query of the incoming request
+	target *url.URL
+	// preserveHost sends the Host header of the client to the fallback instead of its own host
+	preserveHost bool
+}
+
+func newFallbackTransport(next http.RoundTripper, route *router.Route, req *http.Request) *fallbackTransport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	target := expandTarget(route.Fallback.Target, req)
+	switch {
+	case target.RawQuery == "":
+		target.RawQuery = req.URL.RawQuery
+	case req.URL.RawQuery != "":
+		target.RawQuery += "&" + req.URL.RawQuery
+	}
+
+	return &fallbackTransport{next: next, fallback: route.Fallback, target: target, preserveHost: route.PreserveHost}
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable, err := bufferBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case !replayable || req.Context().Err() != nil:
+		return resp, err
+	case err != nil:
+		slog.WarnContext(req.Context(), "upstream failed, sending request to fallback", "url", req.URL.String(), "error", err)
+	case statusAllowed(resp.StatusCode, t.fallback.Statuses):
+		slog.WarnContext(req.Context(), "upstream failed, sending request to fallback", "url", req.URL.String(), "status", resp.StatusCode)
+		resp.Body.Close() //nolint:errcheck
+	default:
+		return resp, nil
+	}
+
+	fallbackReq := req.Clone(req.Context())
+	fallbackReq.URL = t.target
+	// The Host header follows the fallback unless the client one is preserved, the host
+	// override of the route being meant for its target.
+	if !t.preserveHost {
+		fallbackReq.Host = t.target.Host
+	}
+
+	if req.GetBody != nil {
+		if fallbackReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err = t.fallback.Transport.RoundTrip(fallbackReq)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header.Set(FallbackHeader, "true")
+
+	return resp, nil
+}
+
+// bufferBody reads the body of req in memory so that it can be sent again, returning false
+// if the body is too large to be buffered
+func bufferBody(req *http.Request) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxFallbackBodyBytes+1))
+	if err != nil {
+		return false, err
+	}
+
+	if len(body) > maxFallbackBodyBytes {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+
+		return false, nil
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return true, nil
+}
@@ -0,0 +1,171 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_Fallback(t *testing.T) {
+	t.Parallel()
+
+	// newGateway returns a gateway proxying /test/{id} to primary, falling back to fallback
+	newGateway := func(t *testing.T, primary, fallback http.HandlerFunc, method string, statuses ...string) *proxy.Handler {
+		primaryServer := httptest.NewServer(primary)
+		t.Cleanup(primaryServer.Close)
+
+		fallbackServer := httptest.NewServer(fallback)
+		t.Cleanup(fallbackServer.Close)
+
+		primaryURL, err := url.Parse(primaryServer.URL + "/items/{id}")
+		require.NoError(t, err)
+
+		fallbackURL, err := url.Parse(fallbackServer.URL + "/snapshot/items/{id}")
+		require.NoError(t, err)
+
+		if len(statuses) == 0 {
+			statuses = []string{"5xx"}
+		}
+
+		route := &router.Route{
+			OriginalPath: "/test/{id}",
+			Target:       primaryURL,
+			Method:       method,
+			HeaderMode:   "passthrough",
+			Fallback:     &router.Fallback{Target: fallbackURL, Statuses: statuses, Transport: http.DefaultTransport},
+		}
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test/42", method, route)
+
+		return proxy.NewHandler(mockRouter)
+	}
+
+	t.Run("it should send the request to the fallback when the target fails", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.RequestURI())) //nolint:errcheck
+		}, http.MethodGet)
+
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test/42?fields=name", nil)
+		req.SetPathValue("id", "42")
+		gateway.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "/snapshot/items/42?fields=name", recorder.Body.String())
+		require.Equal(t, "true", recorder.Header().Get(proxy.FallbackHeader))
+	})
+
+	t.Run("it should replay the request body to the fallback", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			io.ReadAll(r.Body) //nolint:errcheck
+			w.WriteHeader(http.StatusBadGateway)
+		}, func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body) //nolint:errcheck
+		}, http.MethodPut)
+
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/test/42", strings.NewReader("payload")))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "payload", recorder.Body.String())
+		require.Equal(t, "true", recorder.Header().Get(proxy.FallbackHeader))
+	})
+
+	t.Run("it should send the request to the fallback when the target is unreachable", func(t *testing.T) {
+		primary := httptest.NewServer(http.NotFoundHandler())
+		primaryURL, err := url.Parse(primary.URL)
+		require.NoError(t, err)
+		primary.Close()
+
+		fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("fallback")) //nolint:errcheck
+		}))
+		defer fallback.Close()
+
+		fallbackURL, err := url.Parse(fallback.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:   primaryURL,
+			Method:   http.MethodGet,
+			Fallback: &router.Fallback{Target: fallbackURL, Statuses: []string{"503"}, Transport: http.DefaultTransport},
+		})
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(mockRouter).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "fallback", recorder.Body.String())
+		require.Equal(t, "true", recorder.Header().Get(proxy.FallbackHeader))
+	})
+
+	t.Run("it should send the fallback its own host unless the client one is preserved", func(t *testing.T) {
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer primary.Close()
+
+		fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host)) //nolint:errcheck
+		}))
+		defer fallback.Close()
+
+		primaryURL, err := url.Parse(primary.URL)
+		require.NoError(t, err)
+
+		fallbackURL, err := url.Parse(fallback.URL)
+		require.NoError(t, err)
+
+		for _, tc := range []struct {
+			route    router.Route
+			expected string
+		}{
+			{route: router.Route{}, expected: fallbackURL.Host},
+			{route: router.Route{HostOverride: "api.internal"}, expected: fallbackURL.Host},
+			{route: router.Route{PreserveHost: true}, expected: "shop.example.com"},
+		} {
+			route := tc.route
+			route.Target = primaryURL
+			route.Method = http.MethodGet
+			route.Fallback = &router.Fallback{Target: fallbackURL, Statuses: []string{"503"}, Transport: http.DefaultTransport}
+
+			mockRouter := &mockRouter{}
+			mockRouter.addRoute("/test", http.MethodGet, &route)
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Host = "shop.example.com"
+
+			recorder := httptest.NewRecorder()
+			proxy.NewHandler(mockRouter).ServeHTTP(recorder, req)
+
+			require.Equal(t, http.StatusOK, recorder.Code)
+			require.Equal(t, tc.expected, recorder.Body.String())
+		}
+	})
+
+	t.Run("it should keep the response of the target for other statuses", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, func(w http.ResponseWriter, r *http.Request) {
+			t.Error("the fallback should not be called")
+		}, http.MethodGet, "503")
+
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test/42", nil))
+
+		require.Equal(t, http.StatusNotFound, recorder.Code)
+		require.Empty(t, recorder.Header().Get(proxy.FallbackHeader))
+	})
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// grpcWebContentType returns the gRPC content type matching a gRPC-Web one, and whether
+// the request uses the base64 text variant.
+func grpcWebContentType(contentType string) (string, bool, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false, false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "application/grpc-web-text"):
+		return "application/grpc" + strings.TrimPrefix(mediaType, "application/grpc-web-text"), true, true
+	case strings.HasPrefix(mediaType, "application/grpc-web"):
+		return "application/grpc" + strings.TrimPrefix(mediaType, "application/grpc-web"), false, true
+	default:
+		return "", false, false
+	}
+}
+
+// translateGRPCWebRequest turns a gRPC-Web request into a native gRPC request
+func translateGRPCWebRequest(req *http.Request) {
+	contentType, text, ok := grpcWebContentType(req.Header.Get("Content-Type"))
+	if !ok {
+		return
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Del("X-Grpc-Web")
+
+	if text && req.Body != nil {
+		req.Body = &base64Reader{src: req.Body}
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+	}
+}
+
+// translateGRPCWebResponse turns a native gRPC response into a gRPC-Web one: the body is
+// passed through (base64 encoded for the text variant) and the trailers are appended as
+// a trailer frame.
+func translateGRPCWebResponse(resp *http.Response, requestContentType string) {
+	_, text, _ := grpcWebContentType(requestContentType)
+
+	contentType := "application/grpc-web" + strings.TrimPrefix(resp.Header.Get("Content-Type"), "application/grpc")
+	if text {
+		contentType = "application/grpc-web-text" + strings.TrimPrefix(resp.Header.Get("Content-Type"), "application/grpc")
+	}
+
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+
+	resp.Body = &grpcWebResponseBody{
+		body: resp.Body,
+		resp: resp,
+		text: text,
+	}
+
+	// Trailers are sent in the body, not as HTTP trailers.
+	resp.Trailer = nil
+}
+
+// base64Reader decodes a grpc-web-text body, made of one or more padded base64 segments
+type base64Reader struct {
+	src     io.ReadCloser
+	pending []byte
+	decoded []byte
+	err     error
+}
+
+func (r *base64Reader) Read(p []byte) (int, error) {
+	for len(r.decoded) == 0 {
+		if r.err != nil {
+			if r.err == io.EOF && len(r.pending) > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+
+			return 0, r.err
+		}
+
+		buf := make([]byte, 4096)
+		n, err := r.src.Read(buf)
+		r.err = err
+
+		for _, c := range buf[:n] {
+			if c != '\r' && c != '\n' {
+				r.pending = append(r.pending, c)
+			}
+		}
+
+		// Each group of 4 characters is decoded independently, as segments may be padded.
+		for len(r.pending) >= 4 {
+			var group [3]byte
+			m, err := base64.StdEncoding.Decode(group[:], r.pending[:4])
+			if err != nil {
+				return 0, err
+			}
+
+			r.decoded = append(r.decoded, group[:m]...)
+			r.pending = r.pending[4:]
+		}
+	}
+
+	n := copy(p, r.decoded)
+	r.decoded = r.decoded[n:]
+	return n, nil
+}
+
+func (r *base64Reader) Close() error {
+	return r.src.Close()
+}
+
+// grpcWebResponseBody streams the upstream body, then the trailer frame
+type grpcWebResponseBody struct {
+	body    io.ReadCloser
+	resp    *http.Response
+	text    bool
+	pending []byte
+	done    bool
+}
+
+func (b *grpcWebResponseBody) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+
+		buf := make([]byte, 32*1024)
+		n, err := b.body.Read(buf)
+		if n > 0 {
+			b.pending = append(b.pending, b.encode(buf[:n])...)
+		}
+
+		if err == io.EOF {
+			b.pending = append(b.pending, b.encode(b.trailerFrame())...)
+			b.resp.Trailer = nil
+			b.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *grpcWebResponseBody) Close() error {
+	return b.body.Close()
+}
+
+func (b *grpcWebResponseBody) encode(data []byte) []byte {
+	if !b.text {
+		return data
+	}
+
+	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+	base64.StdEncoding.Encode(encoded, data)
+	return encoded
+}
+
+// trailerFrame encodes the upstream trailers as a gRPC-Web trailer frame. The status of
+// trailers-only responses is read from the headers.
+func (b *grpcWebResponseBody) trailerFrame() []byte {
+	trailers := make(http.Header)
+	for _, name := range []string{"Grpc-Status", "Grpc-Message"} {
+		if value := b.resp.Header.Get(name); value != "" {
+			trailers.Set(name, value)
+		}
+	}
+
+	for name, values := range b.resp.Trailer {
+		trailers[http.CanonicalHeaderKey(name)] = values
+	}
+
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var payload bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailers[name] {
+			payload.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+
+	frame := make([]byte, 5, 5+payload.Len())
+	frame[0] = 0x80
+	binary.BigEndian.PutUint32(frame[1:], uint32(payload.Len()))
+	return append(frame, payload.Bytes()...)
+}
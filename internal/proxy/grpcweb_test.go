@@ -0,0 +1,149 @@
+package proxy_test
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_GRPCWeb(t *testing.T) {
+	t.Parallel()
+
+	message := []byte{0, 0, 0, 0, 2, 0x08, 0x01}
+	trailerFrame := append([]byte{0x80, 0, 0, 0, 36}, []byte("grpc-message: done\r\ngrpc-status: 0\r\n")...)
+
+	newRoute := func(t *testing.T) (*router.Route, func()) {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+
+		backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, 2, r.ProtoMajor)
+			require.Equal(t, "application/grpc+proto", r.Header.Get("Content-Type"))
+			require.Equal(t, "trailers", r.Header.Get("Te"))
+
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, message, body)
+
+			w.Header().Set("Content-Type", "application/grpc+proto")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(http.StatusOK)
+			w.Write(message) //nolint:errcheck
+			w.Header().Set("Grpc-Status", "0")
+			w.Header().Set("Grpc-Message", "done")
+		}))
+		backend.Config.Protocols = protocols
+		backend.Start()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		rt, err := transport.New(config.EndpointConfig{Protocol: config.ProtocolGRPCWeb}, targetURL, "")
+		require.NoError(t, err)
+
+		return &router.Route{
+			Target:    targetURL,
+			Method:    http.MethodPost,
+			Protocol:  config.ProtocolGRPCWeb,
+			Transport: rt,
+		}, backend.Close
+	}
+
+	t.Run("it should translate binary gRPC-Web calls", func(t *testing.T) {
+		route, closeBackend := newRoute(t)
+		defer closeBackend()
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodPost, route)
+
+		gateway := httptest.NewServer(proxy.NewHandler(mockRouter))
+		defer gateway.Close()
+
+		resp, err := http.Post(gateway.URL+"/helloworld.Greeter/SayHello", "application/grpc-web+proto", strings.NewReader(string(message)))
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/grpc-web+proto", resp.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, append(append([]byte{}, message...), trailerFrame...), body)
+		require.Empty(t, resp.Trailer)
+	})
+
+	t.Run("it should translate base64 text gRPC-Web calls", func(t *testing.T) {
+		route, closeBackend := newRoute(t)
+		defer closeBackend()
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodPost, route)
+
+		gateway := httptest.NewServer(proxy.NewHandler(mockRouter))
+		defer gateway.Close()
+
+		encoded := base64.StdEncoding.EncodeToString(message)
+		resp, err := http.Post(gateway.URL+"/helloworld.Greeter/SayHello", "application/grpc-web-text+proto", strings.NewReader(encoded))
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "application/grpc-web-text+proto", resp.Header.Get("Content-Type"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		// The body is made of padded base64 segments, decoded 4 characters at a time
+		var decoded []byte
+		for i := 0; i+4 <= len(body); i += 4 {
+			chunk, err := base64.StdEncoding.DecodeString(string(body[i : i+4]))
+			require.NoError(t, err)
+			decoded = append(decoded, chunk...)
+		}
+		require.Equal(t, append(append([]byte{}, message...), trailerFrame...), decoded)
+	})
+
+	t.Run("it should answer preflight requests and report errors as gRPC-Web statuses", func(t *testing.T) {
+		targetURL, _ := url.Parse("http://invalid.example.test:1")
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodOptions, &router.Route{
+			Target:   targetURL,
+			Method:   http.MethodOptions,
+			Protocol: config.ProtocolGRPCWeb,
+		})
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodPost, &router.Route{
+			Target:   targetURL,
+			Method:   http.MethodPost,
+			Protocol: config.ProtocolGRPCWeb,
+		})
+
+		handler := proxy.NewHandler(mockRouter)
+
+		req := httptest.NewRequest(http.MethodOptions, "/helloworld.Greeter/SayHello", nil)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusNoContent, recorder.Code)
+
+		req = httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", "application/grpc-web+proto")
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "application/grpc-web+proto", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "14", recorder.Header().Get("Grpc-Status"))
+	})
+}
@@ -3,11 +3,22 @@ package proxy
 import (
 	"context"
 	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/grpcstatus"
+	"github.com/arthurdotwork/heimdall/internal/headers"
 	"github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/internal/problem"
 	"github.com/arthurdotwork/heimdall/internal/router"
 )
 
@@ -21,6 +32,10 @@ type Router interface {
 type Handler struct {
 	router    Router
 	proxyFunc func(target *url.URL) *httputil.ReverseProxy
+	upgrades  *upgradeTracker
+	handlers  *handlerRegistry
+	coalescer *coalescer
+	errors    *problem.Renderer
 }
 
 func NewHandler(router Router) *Handler {
@@ -29,6 +44,9 @@ func NewHandler(router Router) *Handler {
 		proxyFunc: func(target *url.URL) *httputil.ReverseProxy {
 			return httputil.NewSingleHostReverseProxy(target)
 		},
+		upgrades:  newUpgradeTracker(),
+		handlers:  newHandlerRegistry(),
+		coalescer: newCoalescer(),
 	}
 }
 
@@ -36,7 +54,7 @@ func (p *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	select {
 	case <-req.Context().Done():
 		w.Header().Set("Connection", "close")
-		w.WriteHeader(http.StatusServiceUnavailable)
+		p.errors.Render(w, req, problem.New(http.StatusServiceUnavailable, problem.CodeShuttingDown, "Gateway is shutting down"))
 		return
 	default:
 		// normal processing..
@@ -44,10 +62,26 @@ func (p *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	route, ok := p.router.GetRoute(req.URL.Path, req.Method)
 	if !ok {
-		http.Error(w, "Route Not Found", http.StatusNotFound)
+		if grpcstatus.IsGRPCRequest(req) {
+			grpcstatus.WriteError(w, grpcstatus.Unimplemented, "Route Not Found")
+			return
+		}
+
+		if grpcstatus.IsGRPCWebRequest(req) {
+			grpcstatus.WriteWebError(w, grpcstatus.Unimplemented, "Route Not Found")
+			return
+		}
+
+		p.errors.Render(w, req, problem.New(http.StatusNotFound, problem.CodeRouteNotFound, "Route Not Found"))
 		return
 	}
 
+	// Middlewares read the settings of the endpoint from the matched route.
+	req = req.WithContext(router.WithRoute(req.Context(), route))
+	for name, value := range route.PathValues(req.URL.Path) {
+		req.SetPathValue(name, value)
+	}
+
 	// If the route has a handler (with middleware), use it
 	if route.Handler != nil {
 		route.Handler.ServeHTTP(w, req)
@@ -58,12 +92,17 @@ func (p *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	p.proxyRequest(w, req, route)
 }
 
+// SetErrorRenderer sets the renderer of the errors not bound to a route, such as unknown routes
+func (p *Handler) SetErrorRenderer(renderer *problem.Renderer) {
+	p.errors = renderer
+}
+
 // InitializeRouteHandlers initializes handlers for all routes with middleware
 func (p *Handler) InitializeRouteHandlers(globalMiddleware *middleware.Chain) {
 	p.router.ApplyGlobalMiddleware(globalMiddleware, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		route, ok := p.router.GetRoute(req.URL.Path, req.Method)
 		if !ok {
-			http.Error(w, "Route Not Found", http.StatusNotFound)
+			p.errors.Render(w, req, problem.New(http.StatusNotFound, problem.CodeRouteNotFound, "Route Not Found"))
 			return
 		}
 
@@ -72,48 +111,312 @@ func (p *Handler) InitializeRouteHandlers(globalMiddleware *middleware.Chain) {
 }
 
 func (p *Handler) proxyRequest(w http.ResponseWriter, req *http.Request, route *router.Route) {
-	// Create a new URL based on the target
-	targetURL := &url.URL{
+	if coalescable(req, route) {
+		p.serveCoalesced(w, req, route)
+		return
+	}
+
+	p.forward(w, req, route)
+}
+
+// forward sends the request to the target of the route
+func (p *Handler) forward(w http.ResponseWriter, req *http.Request, route *router.Route) {
+	if route.MaxBodyBytes > 0 {
+		// Reject early when the announced body is too large, and cap streamed bodies otherwise.
+		if req.ContentLength > route.MaxBodyBytes {
+			w.Header().Set("Connection", "close")
+			p.writeError(w, req, route, problem.New(http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "Request body too large"))
+			return
+		}
+
+		if req.Body != nil {
+			req.Body = http.MaxBytesReader(w, req.Body, route.MaxBodyBytes)
+		}
+	}
+
+	if route.Target.Scheme == handlerScheme {
+		p.serveHandler(w, req, route)
+		return
+	}
+
+	if route.Aggregate != nil {
+		p.serveAggregate(w, req, route)
+		return
+	}
+
+	// gRPC-Web preflight requests not answered by a CORS middleware are not forwarded.
+	requestContentType := req.Header.Get("Content-Type")
+	if route.Protocol == config.ProtocolGRPCWeb {
+		if req.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// Tell the upstream that trailers are supported, as gRPC requires.
+		req.Header.Set("Te", "trailers")
+	}
+
+	// Create a new URL based on the target, with the path values of the request
+	targetURL := expandTarget(&url.URL{
 		Scheme:   route.Target.Scheme,
 		Host:     route.Target.Host,
 		Path:     route.Target.Path,
 		RawQuery: route.Target.RawQuery,
 		Opaque:   route.Target.Opaque,
+	}, req)
+
+	// Pooled routes send each request to the next host of the pool.
+	host := targetURL.Host
+	if route.Upstream != nil {
+		upstreamURL, ok := route.Upstream.Next()
+		if !ok {
+			p.writeError(w, req, route, problem.New(http.StatusServiceUnavailable, problem.CodeNoUpstream, "No upstream available"))
+			return
+		}
+
+		targetURL.Host = upstreamURL.Host
+		if upstreamURL.Scheme != "" {
+			targetURL.Scheme = upstreamURL.Scheme
+		}
+
+		host = route.Upstream.HostHeader(upstreamURL)
 	}
 
 	proxy := p.proxyFunc(targetURL)
+	proxy.FlushInterval = route.FlushInterval
+	if route.Transport != nil {
+		proxy.Transport = route.Transport
+	}
+
+	// Requests failed by the target are sent to the fallback of the route.
+	if route.Fallback != nil {
+		proxy.Transport = newFallbackTransport(proxy.Transport, route, req)
+	}
 
 	// Modify the director function
 	originalDirector := proxy.Director
 	proxy.Director = func(req *http.Request) {
 		originalDirector(req)
-		req.Host = targetURL.Host
-		req.URL.Path = targetURL.Path
 
-		// Process headers
-		p.processHeaders(req, route)
+		// gRPC requests keep their /package.Service/Method path, joined to the target path.
+		if !config.IsGRPC(route.Protocol) {
+			req.URL.Path, req.URL.RawPath = targetURL.Path, targetURL.RawPath
+		}
+
+		if route.Protocol == config.ProtocolGRPCWeb {
+			translateGRPCWebRequest(req)
+		}
+
+		p.direct(req, route, host)
+	}
+
+	var exceeded atomic.Bool
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if err := checkResponse(resp, route); err != nil {
+			return err
+		}
+
+		// Informational responses have no body, and the one of 101 Switching Protocols is the upgraded stream.
+		if route.MaxResponseBytes > 0 && resp.StatusCode >= http.StatusOK {
+			resp.Body = &limitedBody{ReadCloser: resp.Body, req: req, route: route, exceeded: &exceeded}
+		}
+
+		rewriteResponse(resp, req, route)
+		route.ResponseHeaders.Filter(resp.Header)
+		route.ResponseHeaders.Apply(resp.Header)
+
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			p.trackUpgrade(w, resp, route)
+		}
+
+		if route.Protocol == config.ProtocolGRPCWeb && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+			translateGRPCWebResponse(resp, requestContentType)
+		}
+
+		// Long-lived streams must not be cut by the server write timeout.
+		if isStreaming(resp, route) {
+			_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		}
+
+		return nil
 	}
 
+	// Errors are rendered for the incoming request: the outgoing one has a rewritten path and filtered headers.
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			slog.WarnContext(r.Context(), "request body too large", "path", route.OriginalPath, "limit", maxBytesErr.Limit)
+			w.Header().Set("Connection", "close")
+			p.writeError(w, req, route, problem.New(http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, "Request body too large"))
+			return
+		}
+
+		var invalidErr *invalidResponseError
+		if errors.As(err, &invalidErr) {
+			slog.ErrorContext(r.Context(), "invalid upstream response", "path", route.OriginalPath, "error", err)
+			p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeInvalidResponse, invalidErr.detail))
+			return
+		}
+
 		if !errors.Is(r.Context().Err(), context.Canceled) {
-			w.WriteHeader(http.StatusBadGateway)
-			w.Write([]byte("Gateway error")) //nolint:errcheck
+			p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeUpstreamError, "Gateway error"))
 			return
 		}
 
 		w.Header().Set("Connection", "close")
-		w.WriteHeader(http.StatusServiceUnavailable)
-		w.Write([]byte("Gateway is shutting down")) //nolint:errcheck
+		p.writeError(w, req, route, problem.New(http.StatusServiceUnavailable, problem.CodeShuttingDown, "Gateway is shutting down"))
+	}
+
+	if route.MaxResponseBytes == 0 {
+		proxy.ServeHTTP(w, req)
+		return
+	}
+
+	// Responses exceeding the limit before their first byte is sent are replaced by an error,
+	// the others are aborted.
+	guard := &responseGuard{ResponseWriter: w}
+	header := w.Header().Clone()
+	defer func() {
+		if !exceeded.Load() || guard.committed {
+			guard.commit()
+			return
+		}
+
+		if v := recover(); v != nil && v != http.ErrAbortHandler {
+			panic(v)
+		}
+
+		clear(w.Header())
+		maps.Copy(w.Header(), header)
+		p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeInvalidResponse, "Upstream response too large"))
+	}()
+
+	proxy.ServeHTTP(guard, req)
+}
+
+// writeError writes an error generated by the gateway with the error renderer of the route.
+// gRPC routes receive the matching gRPC status instead.
+func (p *Handler) writeError(w http.ResponseWriter, req *http.Request, route *router.Route, pb problem.Problem) {
+	switch route.Protocol {
+	case config.ProtocolGRPC:
+		grpcstatus.WriteError(w, grpcstatus.FromHTTPStatus(pb.Status), pb.Detail)
+		return
+	case config.ProtocolGRPCWeb:
+		grpcstatus.WriteWebError(w, grpcstatus.FromHTTPStatus(pb.Status), pb.Detail)
+		return
 	}
 
-	proxy.ServeHTTP(w, req)
+	route.Errors.Render(w, req, pb)
+}
+
+// isStreaming reports whether the response is a long-lived stream: server-sent events,
+// or any response of a route configured to flush after every write.
+func isStreaming(resp *http.Response, route *router.Route) bool {
+	if route.FlushInterval < 0 {
+		return true
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// ActiveUpgrades returns the number of active upgraded (e.g. WebSocket) connections
+func (p *Handler) ActiveUpgrades() int {
+	return p.upgrades.active()
+}
+
+// CloseUpgrades closes every active upgraded connection
+func (p *Handler) CloseUpgrades() {
+	p.upgrades.closeAll()
+}
+
+// trackUpgrade wraps the backend connection of a switched protocol response so that it is
+// counted, closed on shutdown and subject to the idle timeout of the route.
+func (p *Handler) trackUpgrade(w http.ResponseWriter, resp *http.Response, route *router.Route) {
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return
+	}
+
+	var idleTimeout time.Duration
+	if route.Upgrade != nil {
+		idleTimeout = route.Upgrade.IdleTimeout
+	}
+
+	// Upgraded connections are long-lived: lift the deadlines inherited from the server timeouts.
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	resp.Body = p.upgrades.track(backConn, idleTimeout)
+}
+
+// direct sets the Host header and the headers of a request sent to the target host of the route.
+// The request keeps the Host header of the client when it is preserved.
+func (p *Handler) direct(req *http.Request, route *router.Route, host string) {
+	switch {
+	case route.HostOverride != "":
+		req.Host = route.HostOverride
+	case !route.PreserveHost:
+		req.Host = host
+	}
+
+	p.processHeaders(req, route)
 }
 
 func (p *Handler) processHeaders(req *http.Request, route *router.Route) {
 	allowedHeaderValues := make(map[string][]string)
-	for _, allowedHeader := range route.AllowedHeaders {
-		if values, exists := req.Header[allowedHeader]; exists {
-			allowedHeaderValues[allowedHeader] = values
+
+	if route.HeaderMode == config.HeaderModePassthrough {
+		for header, values := range req.Header {
+			allowedHeaderValues[header] = values
+		}
+	} else {
+		// gRPC metadata (deadlines, encodings, ...) is part of the protocol.
+		if config.IsGRPC(route.Protocol) {
+			for header, values := range req.Header {
+				if header == "Content-Type" || strings.HasPrefix(header, "Grpc-") {
+					allowedHeaderValues[header] = values
+				}
+			}
+		}
+
+		for _, essentialHeader := range route.EssentialHeaders {
+			essentialHeader = http.CanonicalHeaderKey(essentialHeader)
+			if values, exists := req.Header[essentialHeader]; exists {
+				allowedHeaderValues[essentialHeader] = values
+			}
+		}
+
+		for _, allowedHeader := range route.AllowedHeaders {
+			if values, exists := req.Header[allowedHeader]; exists {
+				allowedHeaderValues[allowedHeader] = values
+			}
+		}
+
+		// Headers matching the allow patterns of the policy are forwarded as well.
+		for header, values := range req.Header {
+			if route.RequestHeaders.Allows(header) {
+				allowedHeaderValues[header] = values
+			}
+		}
+	}
+
+	// Denied headers are never forwarded, whatever the mode.
+	for header := range allowedHeaderValues {
+		if route.RequestHeaders.Denies(header) {
+			delete(allowedHeaderValues, header)
+		}
+	}
+
+	// Upgrade requests keep their handshake headers when the route accepts the protocol.
+	if protocol := upgradeType(req.Header); upgradeAllowed(route, protocol) {
+		allowedHeaderValues["Connection"] = []string{"Upgrade"}
+		allowedHeaderValues["Upgrade"] = []string{protocol}
+		for header, values := range req.Header {
+			if strings.HasPrefix(header, "Sec-Websocket-") {
+				allowedHeaderValues[header] = values
+			}
 		}
 	}
 
@@ -131,5 +434,12 @@ func (p *Handler) processHeaders(req *http.Request, route *router.Route) {
 		}
 	}
 
+	// Headers set by middlewares, such as authenticated identities, are trusted and replace the client ones.
+	for header, values := range headers.UpstreamFromContext(req.Context()) {
+		req.Header[header] = values
+	}
+
 	req.Header.Set("User-Agent", defaultUserAgent)
+
+	route.RequestHeaders.Apply(req.Header)
 }
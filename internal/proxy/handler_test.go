@@ -1,16 +1,30 @@
 package proxy_test
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/fcgi"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/headers"
 	"github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/internal/problem"
 	"github.com/arthurdotwork/heimdall/internal/proxy"
 	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/arthurdotwork/heimdall/internal/upstream"
 	"github.com/stretchr/testify/require"
 )
 
@@ -46,6 +60,19 @@ func (m *mockRouter) ApplyGlobalMiddleware(middlewareChain *middleware.Chain, fi
 	}
 }
 
+func requireProblem(t *testing.T, recorder *httptest.ResponseRecorder, code, detail string) {
+	t.Helper()
+
+	require.Equal(t, problem.ContentType, recorder.Header().Get("Content-Type"))
+
+	var pb problem.Problem
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &pb))
+	require.Equal(t, recorder.Code, pb.Status)
+	require.Equal(t, code, pb.Code)
+	require.Equal(t, detail, pb.Detail)
+	require.NotEmpty(t, pb.RequestID)
+}
+
 func TestProxyHandler_Serve(t *testing.T) {
 	t.Parallel()
 
@@ -59,7 +86,7 @@ func TestProxyHandler_Serve(t *testing.T) {
 
 		proxy.ServeHTTP(recorder, req)
 		require.Equal(t, http.StatusNotFound, recorder.Code)
-		require.Equal(t, "Route Not Found\n", recorder.Body.String())
+		requireProblem(t, recorder, problem.CodeRouteNotFound, "Route Not Found")
 	})
 
 	t.Run("it should return an error if the method is not allowed", func(t *testing.T) {
@@ -72,7 +99,7 @@ func TestProxyHandler_Serve(t *testing.T) {
 
 		proxy.ServeHTTP(recorder, req)
 		require.Equal(t, http.StatusNotFound, recorder.Code)
-		require.Equal(t, "Route Not Found\n", recorder.Body.String())
+		requireProblem(t, recorder, problem.CodeRouteNotFound, "Route Not Found")
 	})
 
 	t.Run("it should proxy the request", func(t *testing.T) {
@@ -119,6 +146,513 @@ func TestProxyHandler_Serve(t *testing.T) {
 		require.Equal(t, http.StatusOK, recorder.Code)
 	})
 
+	t.Run("it should apply the request and response header policies", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "acme", r.Header.Get("X-Tenant-Id"))
+			require.Empty(t, r.Header.Get("X-Tenant-Secret"))
+			require.Empty(t, r.Header.Get("Cookie"))
+			require.Equal(t, "renamed", r.Header.Get("X-Renamed"))
+
+			w.Header().Set("Server", "nginx")
+			w.Header().Set("X-Powered-By", "PHP")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		requestHeaders, err := headers.NewPolicy(config.HeaderPolicyConfig{
+			Allow:  []string{"X-Tenant-*", "Cookie", "X-Original"},
+			Deny:   []string{"X-Tenant-Secret", "Cookie"},
+			Rename: map[string]string{"X-Original": "X-Renamed"},
+		})
+		require.NoError(t, err)
+
+		responseHeaders, err := headers.NewPolicy(config.HeaderPolicyConfig{
+			Remove: []string{"Server", "X-Powered-By"},
+			Set:    map[string]string{"X-Served-By": "heimdall"},
+		})
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:          targetURL,
+			Method:          http.MethodGet,
+			RequestHeaders:  requestHeaders,
+			ResponseHeaders: responseHeaders,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Tenant-Id", "acme")
+		req.Header.Set("X-Tenant-Secret", "secret")
+		req.Header.Set("Cookie", "session=1")
+		req.Header.Set("X-Original", "renamed")
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Empty(t, recorder.Header().Get("Server"))
+		require.Empty(t, recorder.Header().Get("X-Powered-By"))
+		require.Equal(t, "heimdall", recorder.Header().Get("X-Served-By"))
+	})
+
+	t.Run("it should keep the response headers not denied when the response policy allows some", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Set-Cookie", "session=1")
+			w.Header().Set("X-Tenant-Id", "acme")
+			w.Header().Set("X-Tenant-Secret", "secret")
+			w.Write([]byte(`{}`)) //nolint:errcheck
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		responseHeaders, err := headers.NewPolicy(config.HeaderPolicyConfig{
+			Allow: []string{"X-Tenant-*"},
+			Deny:  []string{"X-Tenant-Secret"},
+		})
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:          targetURL,
+			Method:          http.MethodGet,
+			ResponseHeaders: responseHeaders,
+		})
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(mockRouter).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "2", recorder.Header().Get("Content-Length"))
+		require.Equal(t, "session=1", recorder.Header().Get("Set-Cookie"))
+		require.Equal(t, "acme", recorder.Header().Get("X-Tenant-Id"))
+		require.Empty(t, recorder.Header().Get("X-Tenant-Secret"))
+	})
+
+	t.Run("it should forward essential headers in allowlist mode", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+			require.Empty(t, r.Header.Get("X-Forbidden-Header"))
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodPost, &router.Route{
+			Target:           targetURL,
+			Method:           http.MethodPost,
+			HeaderMode:       config.HeaderModeAllowlist,
+			EssentialHeaders: config.DefaultEssentialHeaders,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("X-Forbidden-Header", "forbidden-value")
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("it should forward everything but denied headers in passthrough mode", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "value", r.Header.Get("X-Anything"))
+			require.Empty(t, r.Header.Get("Cookie"))
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		requestHeaders, err := headers.NewPolicy(config.HeaderPolicyConfig{Deny: []string{"Cookie"}})
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:         targetURL,
+			Method:         http.MethodGet,
+			HeaderMode:     config.HeaderModePassthrough,
+			RequestHeaders: requestHeaders,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Anything", "value")
+		req.Header.Set("Cookie", "session=1")
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("it should forward the headers set by middlewares in place of the client ones", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "user-1", r.Header.Get("X-User-Id"))
+			require.Empty(t, r.Header.Values("X-User-Email"))
+
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:     targetURL,
+			Method:     http.MethodGet,
+			HeaderMode: config.HeaderModePassthrough,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-User-Id", "admin")
+		req.Header.Set("X-User-Email", "admin@example.com")
+		req = req.WithContext(headers.WithUpstream(req.Context(), http.Header{"X-User-Id": {"user-1"}, "X-User-Email": nil}))
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("it should reject requests whose content length exceeds the limit", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("the request should not reach the backend")
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodPost, &router.Route{
+			Target:       targetURL,
+			Method:       http.MethodPost,
+			MaxBodyBytes: 4,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodPost, "/test", strings.NewReader("too large"))
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		requireProblem(t, recorder, problem.CodeBodyTooLarge, "Request body too large")
+	})
+
+	t.Run("it should stop streamed bodies exceeding the limit", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodPost, &router.Route{
+			Target:       targetURL,
+			Method:       http.MethodPost,
+			MaxBodyBytes: 4,
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		req := httptest.NewRequest(http.MethodPost, "/test", io.NopCloser(strings.NewReader("too large")))
+		req.ContentLength = -1
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+		requireProblem(t, recorder, problem.CodeBodyTooLarge, "Request body too large")
+	})
+
+	t.Run("it should stream server-sent events beyond the write timeout", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := range 3 {
+				fmt.Fprintf(w, "data: %d\n\n", i)
+				require.NoError(t, http.NewResponseController(w).Flush())
+				time.Sleep(100 * time.Millisecond)
+			}
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/events", http.MethodGet, &router.Route{
+			Target:        targetURL,
+			Method:        http.MethodGet,
+			FlushInterval: -1,
+		})
+
+		gateway := httptest.NewUnstartedServer(proxy.NewHandler(mockRouter))
+		gateway.Config.WriteTimeout = 150 * time.Millisecond
+		gateway.Start()
+		defer gateway.Close()
+
+		resp, err := http.Get(gateway.URL + "/events")
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		reader := bufio.NewReader(resp.Body)
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "data: 0\n", line)
+
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		require.Equal(t, "\ndata: 1\n\ndata: 2\n\n", string(body))
+	})
+
+	t.Run("it should proxy the request to a unix socket target", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "heimdall")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir) //nolint:errcheck
+
+		socketPath := filepath.Join(dir, "app.sock")
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+
+		backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("unix:" + r.URL.Path)) //nolint:errcheck
+		})}
+		go backend.Serve(listener) //nolint:errcheck
+		defer backend.Close()      //nolint:errcheck
+
+		r, err := router.New([]config.EndpointConfig{
+			{Path: "/sidecar", Target: "unix://" + socketPath + ":/status", Method: http.MethodGet},
+		})
+		require.NoError(t, err)
+
+		proxy := proxy.NewHandler(r)
+		req := httptest.NewRequest(http.MethodGet, "/sidecar", nil)
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "unix:/status", recorder.Body.String())
+	})
+
+	t.Run("it should serve fastcgi targets", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close() //nolint:errcheck
+
+		go fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { //nolint:errcheck
+			w.Header().Set("X-Powered-By", "PHP")
+			w.Write([]byte("script:" + fcgi.ProcessEnv(r)["SCRIPT_FILENAME"] + " user-agent:" + r.UserAgent())) //nolint:errcheck
+		}))
+
+		r, err := router.New([]config.EndpointConfig{{
+			Path:            "/tools",
+			Target:          "fastcgi://" + listener.Addr().String() + "/var/www/tools.php",
+			Method:          http.MethodGet,
+			ResponseHeaders: config.HeaderPolicyConfig{Remove: []string{"X-Powered-By"}},
+		}})
+		require.NoError(t, err)
+
+		proxy := proxy.NewHandler(r)
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/tools", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "script:/var/www/tools.php user-agent:Heimdall/0.1", recorder.Body.String())
+		require.Empty(t, recorder.Header().Get("X-Powered-By"))
+	})
+
+	t.Run("it should send the host and port of the gateway to scripts", func(t *testing.T) {
+		script := filepath.Join(t.TempDir(), "server.cgi")
+		content := "#!/bin/sh\nprintf 'Content-Type: text/plain\\r\\n\\r\\n'\nprintf '%s %s' \"$SERVER_NAME\" \"$SERVER_PORT\"\n"
+		require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer listener.Close() //nolint:errcheck
+
+		go fcgi.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { //nolint:errcheck
+			w.Write([]byte(r.Host)) //nolint:errcheck
+		}))
+
+		r, err := router.New([]config.EndpointConfig{
+			{Path: "/cgi", Target: "cgi://" + script, Method: http.MethodGet},
+			{Path: "/fastcgi", Target: "fastcgi://" + listener.Addr().String() + "/var/www/index.php", Method: http.MethodGet},
+		})
+		require.NoError(t, err)
+
+		gateway := httptest.NewServer(proxy.NewHandler(r))
+		defer gateway.Close()
+
+		gatewayURL, err := url.Parse(gateway.URL)
+		require.NoError(t, err)
+
+		get := func(path string) string {
+			req, err := http.NewRequest(http.MethodGet, gateway.URL+path, nil)
+			require.NoError(t, err)
+			req.Host = "shop.example.com"
+
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close() //nolint:errcheck
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			return string(body)
+		}
+
+		require.Equal(t, "shop.example.com "+gatewayURL.Port(), get("/cgi"))
+		require.Equal(t, "shop.example.com", get("/fastcgi"))
+	})
+
+	t.Run("it should serve handler targets with the registered handler", func(t *testing.T) {
+		r, err := router.New([]config.EndpointConfig{
+			{Path: "/whoami", Target: "handler://whoami", Method: http.MethodGet},
+			{Path: "/missing", Target: "handler://missing", Method: http.MethodGet},
+		})
+		require.NoError(t, err)
+
+		proxy := proxy.NewHandler(r)
+		err = proxy.RegisterHandler("whoami", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("heimdall " + r.URL.Path)) //nolint:errcheck
+		}))
+		require.NoError(t, err)
+		require.NoError(t, proxy.CheckHandlers([]string{"whoami"}))
+		require.EqualError(t, proxy.CheckHandlers(r.Handlers()), `handler "missing" is not registered`)
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/whoami", nil))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "heimdall /whoami", recorder.Body.String())
+
+		recorder = httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/missing", nil))
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+	})
+
+	t.Run("it should balance the requests over the upstream pool", func(t *testing.T) {
+		var targets []*url.URL
+		for _, name := range []string{"first", "second"} {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(name + ":" + r.URL.Path)) //nolint:errcheck
+			}))
+			defer server.Close()
+
+			u, _ := url.Parse(server.URL)
+			targets = append(targets, &url.URL{Host: u.Host})
+		}
+
+		target, _ := url.Parse("http://api.internal/v1")
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:   target,
+			Method:   http.MethodGet,
+			Upstream: upstream.NewPool(targets, nil, 0),
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+
+		var bodies []string
+		for range 3 {
+			recorder := httptest.NewRecorder()
+			proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+			require.Equal(t, http.StatusOK, recorder.Code)
+			bodies = append(bodies, recorder.Body.String())
+		}
+
+		require.Equal(t, []string{"first:/v1", "second:/v1", "first:/v1"}, bodies)
+	})
+
+	t.Run("it should send the host of the target to the discovered upstream targets", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Host)) //nolint:errcheck
+		}))
+		defer server.Close()
+
+		discovered, _ := url.Parse(server.URL)
+		file := filepath.Join(t.TempDir(), "targets.yaml")
+		require.NoError(t, os.WriteFile(file, []byte("- "+discovered.Host), 0o600))
+
+		target, _ := url.Parse("http://api.internal:8080/v1")
+		pool, err := upstream.New(config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: config.DiscoveryFile, File: file}}, target)
+		require.NoError(t, err)
+		require.NoError(t, pool.Refresh(context.Background()))
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{Target: target, Method: http.MethodGet, Upstream: pool})
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(mockRouter).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "api.internal:8080", recorder.Body.String())
+	})
+
+	t.Run("it should return a 503 if the upstream pool is empty", func(t *testing.T) {
+		target, _ := url.Parse("http://api.internal/v1")
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, &router.Route{
+			Target:   target,
+			Method:   http.MethodGet,
+			Upstream: upstream.NewPool(nil, nil, 0),
+		})
+
+		proxy := proxy.NewHandler(mockRouter)
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+		require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		requireProblem(t, recorder, problem.CodeNoUpstream, "No upstream available")
+	})
+
+	t.Run("it should render errors with the templates of the endpoint and the request ID", func(t *testing.T) {
+		r, err := router.New([]config.EndpointConfig{{
+			Path:   "/test",
+			Target: "http://127.0.0.1:1",
+			Method: http.MethodGet,
+			Errors: config.ErrorsConfig{Templates: map[int]config.ErrorTemplateConfig{
+				http.StatusBadGateway: {ContentType: "text/plain", Body: "{{.Code}} {{.RequestID}} {{.Instance}}"},
+			}},
+		}})
+		require.NoError(t, err)
+
+		proxy := proxy.NewHandler(r)
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-Request-Id", "req-42")
+
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		require.Equal(t, "text/plain", recorder.Header().Get("Content-Type"))
+		require.Equal(t, "upstream_error req-42 /test", recorder.Body.String())
+	})
+
 	t.Run("it should handle transport errors", func(t *testing.T) {
 		mockRouter := &mockRouter{}
 		targetURL, _ := url.Parse("http://invalid.example.test:1")
@@ -137,7 +671,7 @@ func TestProxyHandler_Serve(t *testing.T) {
 		proxy.ServeHTTP(recorder, req)
 
 		require.Equal(t, http.StatusBadGateway, recorder.Code)
-		require.Contains(t, recorder.Body.String(), "Gateway error")
+		requireProblem(t, recorder, problem.CodeUpstreamError, "Gateway error")
 	})
 
 	t.Run("it should return 503 when context is canceled", func(t *testing.T) {
@@ -204,7 +738,7 @@ func TestProxyHandler_Serve(t *testing.T) {
 
 		require.Equal(t, http.StatusServiceUnavailable, recorder.Code)
 		require.Equal(t, "close", recorder.Header().Get("Connection"))
-		require.Contains(t, recorder.Body.String(), "Gateway is shutting down")
+		requireProblem(t, recorder, problem.CodeShuttingDown, "Gateway is shutting down")
 	})
 
 	t.Run("it should use route's handler if set", func(t *testing.T) {
@@ -297,4 +831,149 @@ func TestProxyHandler_Serve(t *testing.T) {
 		require.Equal(t, "true", rec.Header().Get("X-Echo-Global-Middleware"),
 			"Middleware header should be passed to backend and echoed back")
 	})
+
+	t.Run("it should forward the Host header configured for the route", func(t *testing.T) {
+		targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Received-Host", r.Host)
+		}))
+		defer targetServer.Close()
+
+		targetURL, err := url.Parse(targetServer.URL)
+		require.NoError(t, err)
+
+		for expected, route := range map[string]*router.Route{
+			targetURL.Host:        {Target: targetURL, Method: http.MethodGet},
+			"gateway.example.com": {Target: targetURL, Method: http.MethodGet, PreserveHost: true},
+			"api.internal":        {Target: targetURL, Method: http.MethodGet, HostOverride: "api.internal"},
+		} {
+			mockRouter := &mockRouter{}
+			mockRouter.addRoute("/test", http.MethodGet, route)
+
+			req := httptest.NewRequest(http.MethodGet, "http://gateway.example.com/test", nil)
+			recorder := httptest.NewRecorder()
+			proxy.NewHandler(mockRouter).ServeHTTP(recorder, req)
+
+			require.Equal(t, http.StatusOK, recorder.Code)
+			require.Equal(t, expected, recorder.Header().Get("X-Received-Host"))
+		}
+	})
+
+	t.Run("it should expose the matched route to middlewares", func(t *testing.T) {
+		route := &router.Route{Cache: &config.CacheConfig{TTL: time.Minute}}
+		route.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			matched, ok := router.RouteFromContext(r.Context())
+			require.True(t, ok)
+			require.Same(t, route, matched)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, route)
+
+		rec := httptest.NewRecorder()
+		proxy.NewHandler(mockRouter).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		require.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
+
+func TestProxyHandler_GRPC(t *testing.T) {
+	t.Parallel()
+
+	h2c := func() *http.Protocols {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		return protocols
+	}
+
+	h2cClient := func() *http.Client {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	}
+
+	t.Run("it should proxy gRPC calls over h2c and preserve trailers", func(t *testing.T) {
+		backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, 2, r.ProtoMajor)
+			require.Equal(t, "/helloworld.Greeter/SayHello", r.URL.Path)
+			require.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+			require.Equal(t, "1S", r.Header.Get("Grpc-Timeout"))
+
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte{0, 0, 0, 0, 0}) //nolint:errcheck
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "not found")
+		}))
+		backend.Config.Protocols = h2c()
+		backend.Start()
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		rt, err := transport.New(config.EndpointConfig{Protocol: config.ProtocolGRPC}, targetURL, "")
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodPost, &router.Route{
+			Target:           targetURL,
+			Method:           http.MethodPost,
+			Protocol:         config.ProtocolGRPC,
+			Transport:        rt,
+			EssentialHeaders: config.DefaultEssentialHeaders,
+		})
+
+		gateway := httptest.NewUnstartedServer(proxy.NewHandler(mockRouter))
+		gateway.Config.Protocols = h2c()
+		gateway.Start()
+		defer gateway.Close()
+
+		req, err := http.NewRequest(http.MethodPost, gateway.URL+"/helloworld.Greeter/SayHello", strings.NewReader("\x00\x00\x00\x00\x00"))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Grpc-Timeout", "1S")
+		req.Header.Set("Te", "trailers")
+
+		resp, err := h2cClient().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, 2, resp.ProtoMajor)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, []byte{0, 0, 0, 0, 0}, body)
+		require.Equal(t, "5", resp.Trailer.Get("Grpc-Status"))
+		require.Equal(t, "not found", resp.Trailer.Get("Grpc-Message"))
+	})
+
+	t.Run("it should map gateway errors to gRPC statuses", func(t *testing.T) {
+		targetURL, _ := url.Parse("http://invalid.example.test:1")
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/helloworld.Greeter/SayHello", http.MethodPost, &router.Route{
+			Target:   targetURL,
+			Method:   http.MethodPost,
+			Protocol: config.ProtocolGRPC,
+		})
+
+		handler := proxy.NewHandler(mockRouter)
+
+		req := httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "14", recorder.Header().Get("Grpc-Status"))
+
+		req = httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/Unknown", nil)
+		req.Header.Set("Content-Type", "application/grpc")
+		recorder = httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "12", recorder.Header().Get("Grpc-Status"))
+	})
 }
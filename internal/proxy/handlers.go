@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// handlerScheme is the target scheme of routes served by in-process handlers, e.g. handler://whoami
+const handlerScheme = "handler"
+
+// handlerRegistry holds the in-process handlers that can be referenced by name
+type handlerRegistry struct {
+	mutex    sync.RWMutex
+	handlers map[string]http.Handler
+}
+
+func newHandlerRegistry() *handlerRegistry {
+	return &handlerRegistry{
+		handlers: make(map[string]http.Handler),
+	}
+}
+
+func (r *handlerRegistry) register(name string, handler http.Handler) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, exists := r.handlers[name]; exists {
+		return fmt.Errorf("handler with name '%s' already registered", name)
+	}
+
+	r.handlers[name] = handler
+	return nil
+}
+
+func (r *handlerRegistry) get(name string) (http.Handler, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handler, exists := r.handlers[name]
+	return handler, exists
+}
+
+// RegisterHandler registers an in-process handler serving the handler://name targets.
+// Handlers are resolved on each request, so they can be registered after the routes are built,
+// the gateway checks that every handler target is registered when it starts.
+func (p *Handler) RegisterHandler(name string, handler http.Handler) error {
+	return p.handlers.register(name, handler)
+}
+
+// CheckHandlers returns an error naming the first of the handlers that is not registered.
+func (p *Handler) CheckHandlers(names []string) error {
+	for _, name := range names {
+		if _, ok := p.handlers.get(name); !ok {
+			return fmt.Errorf("handler %q is not registered", name)
+		}
+	}
+
+	return nil
+}
+
+// serveHandler serves the request with the in-process handler of the route target.
+func (p *Handler) serveHandler(w http.ResponseWriter, req *http.Request, route *router.Route) {
+	handler, ok := p.handlers.get(route.Target.Host)
+	if !ok {
+		slog.ErrorContext(req.Context(), "handler not registered", "path", route.OriginalPath, "handler", route.Target.Host)
+		p.writeError(w, req, route, problem.New(http.StatusBadGateway, problem.CodeHandlerNotRegistered, "Gateway error"))
+		return
+	}
+
+	handler.ServeHTTP(w, req)
+}
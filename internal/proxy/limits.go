@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// errResponseTooLarge is returned by the body of upstream responses exceeding the route limit
+var errResponseTooLarge = errors.New("upstream response too large")
+
+// invalidResponseError is a response rejected by the policy of the route before its body is read
+type invalidResponseError struct {
+	detail string
+}
+
+func (e *invalidResponseError) Error() string {
+	return e.detail
+}
+
+// checkResponse validates the status, content type and announced size of an upstream response
+func checkResponse(resp *http.Response, route *router.Route) error {
+	// Informational responses, such as 101 Switching Protocols, are not subject to the policy.
+	if resp.StatusCode < http.StatusOK {
+		return nil
+	}
+
+	if len(route.AllowedStatuses) > 0 && !statusAllowed(resp.StatusCode, route.AllowedStatuses) {
+		return &invalidResponseError{detail: fmt.Sprintf("Upstream status %d not allowed", resp.StatusCode)}
+	}
+
+	if len(route.AllowedContentTypes) > 0 {
+		contentType := resp.Header.Get("Content-Type")
+		if contentType == "" && (resp.ContentLength == 0 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified) {
+			return nil
+		}
+
+		if !contentTypeAllowed(contentType, route.AllowedContentTypes) {
+			return &invalidResponseError{detail: fmt.Sprintf("Upstream content type %q not allowed", contentType)}
+		}
+	}
+
+	if route.MaxResponseBytes > 0 && resp.ContentLength > route.MaxResponseBytes {
+		return &invalidResponseError{detail: "Upstream response too large"}
+	}
+
+	return nil
+}
+
+// statusAllowed reports whether the status matches a code (404) or a class (2xx) of the list
+func statusAllowed(status int, allowed []string) bool {
+	code := strconv.Itoa(status)
+	for _, pattern := range allowed {
+		if pattern == code || strings.HasSuffix(pattern, "xx") && pattern[0] == code[0] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contentTypeAllowed(contentType string, allowed []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.EqualFold(mediaType, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limitedBody fails reads once the upstream response exceeds the limit of the route
+type limitedBody struct {
+	io.ReadCloser
+	req      *http.Request
+	route    *router.Route
+	read     int64
+	exceeded *atomic.Bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.route.MaxResponseBytes {
+		if b.exceeded.CompareAndSwap(false, true) {
+			slog.ErrorContext(b.req.Context(), "upstream response too large, aborting it", "path", b.route.OriginalPath, "limit", b.route.MaxResponseBytes)
+		}
+
+		return 0, errResponseTooLarge
+	}
+
+	return n, err
+}
+
+// responseGuard delays the headers of the response until its body is written, so that
+// a response failing before its first byte can still be replaced by an error
+type responseGuard struct {
+	http.ResponseWriter
+	status    int
+	committed bool
+}
+
+func (g *responseGuard) WriteHeader(code int) {
+	// Informational responses, such as 101 Switching Protocols, are not the final response.
+	if code < http.StatusOK {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if g.status == 0 {
+		g.status = code
+	}
+}
+
+func (g *responseGuard) Write(p []byte) (int, error) {
+	g.commit()
+	return g.ResponseWriter.Write(p)
+}
+
+// Flush sends the headers and what has been written so far
+func (g *responseGuard) Flush() {
+	g.commit()
+	_ = http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, e.g. to hijack upgraded connections
+func (g *responseGuard) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// commit writes the delayed headers
+func (g *responseGuard) commit() {
+	if g.committed {
+		return
+	}
+
+	g.committed = true
+	if g.status != 0 {
+		g.ResponseWriter.WriteHeader(g.status)
+	}
+}
@@ -0,0 +1,125 @@
+package proxy_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_ResponseLimits(t *testing.T) {
+	t.Parallel()
+
+	// newGateway returns a gateway proxying /test to backend with the limits of route
+	newGateway := func(t *testing.T, backend http.HandlerFunc, route *router.Route) *proxy.Handler {
+		server := httptest.NewServer(backend)
+		t.Cleanup(server.Close)
+
+		targetURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		route.Target, route.Method = targetURL, http.MethodGet
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/test", http.MethodGet, route)
+
+		return proxy.NewHandler(mockRouter)
+	}
+
+	serve := func(gateway http.Handler) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		gateway.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/test", nil))
+		return recorder
+	}
+
+	t.Run("it should forward responses within the limits", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"ok":true}`)) //nolint:errcheck
+		}, &router.Route{MaxResponseBytes: 64, AllowedStatuses: []string{"2xx"}, AllowedContentTypes: []string{"application/json"}})
+
+		recorder := serve(gateway)
+		require.Equal(t, http.StatusCreated, recorder.Code)
+		require.Equal(t, `{"ok":true}`, recorder.Body.String())
+	})
+
+	t.Run("it should reject responses announcing a larger body", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(strings.Repeat("a", 100))) //nolint:errcheck
+		}, &router.Route{MaxResponseBytes: 10})
+
+		recorder := serve(gateway)
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		requireProblem(t, recorder, problem.CodeInvalidResponse, "Upstream response too large")
+	})
+
+	t.Run("it should reject streamed responses exceeding the limit before the first byte", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Upstream", "leaked")
+			for range 100 {
+				w.Write([]byte(strings.Repeat("a", 1024))) //nolint:errcheck
+				http.NewResponseController(w).Flush()      //nolint:errcheck
+			}
+		}, &router.Route{MaxResponseBytes: 10})
+
+		recorder := serve(gateway)
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		require.Empty(t, recorder.Header().Get("X-Upstream"))
+		requireProblem(t, recorder, problem.CodeInvalidResponse, "Upstream response too large")
+	})
+
+	t.Run("it should abort streamed responses exceeding the limit", func(t *testing.T) {
+		started := make(chan struct{})
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("first"))              //nolint:errcheck
+			http.NewResponseController(w).Flush() //nolint:errcheck
+			<-started
+
+			for range 100 {
+				w.Write([]byte(strings.Repeat("a", 1024))) //nolint:errcheck
+			}
+		}, &router.Route{MaxResponseBytes: 1024})
+
+		server := httptest.NewServer(gateway)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/test")
+		close(started)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.Error(t, err)
+		require.LessOrEqual(t, len(body), 1024)
+	})
+
+	t.Run("it should reject statuses that are not allowed", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, &router.Route{AllowedStatuses: []string{"2xx", "404"}})
+
+		recorder := serve(gateway)
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		requireProblem(t, recorder, problem.CodeInvalidResponse, "Upstream status 500 not allowed")
+	})
+
+	t.Run("it should reject content types that are not allowed", func(t *testing.T) {
+		gateway := newGateway(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>")) //nolint:errcheck
+		}, &router.Route{AllowedContentTypes: []string{"application/*"}})
+
+		recorder := serve(gateway)
+		require.Equal(t, http.StatusBadGateway, recorder.Code)
+		requireProblem(t, recorder, problem.CodeInvalidResponse, `Upstream content type "text/html" not allowed`)
+	})
+}
@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// rewriteResponse maps the upstream URLs of the Location, Content-Location and Set-Cookie
+// headers of resp to the gateway, as seen by the client of req
+func rewriteResponse(resp *http.Response, req *http.Request, route *router.Route) {
+	cfg := route.ResponseRewrite
+	if cfg == nil {
+		return
+	}
+
+	m := &urlMapper{cfg: cfg, publicPrefix: req.URL.EscapedPath(), publicOrigin: publicOrigin(req)}
+	if cfg.Auto && resp.Request != nil {
+		// The upstream is known by the target of the route and by the host the request was sent to.
+		m.upstreamHosts = []string{route.Target.Host, resp.Request.URL.Host}
+		m.upstreamPrefix = resp.Request.URL.EscapedPath()
+	}
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		if value := resp.Header.Get(name); value != "" {
+			resp.Header.Set(name, m.location(value))
+		}
+	}
+
+	if cookies := resp.Header.Values("Set-Cookie"); len(cookies) > 0 {
+		rewritten := make([]string, len(cookies))
+		for i, cookie := range cookies {
+			rewritten[i] = m.cookie(cookie)
+		}
+
+		resp.Header["Set-Cookie"] = rewritten
+	}
+}
+
+// publicOrigin returns the scheme and host the client reached the gateway with
+func publicOrigin(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+
+	return scheme + "://" + req.Host
+}
+
+type urlMapper struct {
+	cfg *config.ResponseRewriteConfig
+
+	// upstreamHosts and upstreamPrefix are mapped to publicOrigin and publicPrefix in automatic mode
+	upstreamHosts  []string
+	upstreamPrefix string
+	publicOrigin   string
+	publicPrefix   string
+}
+
+// location rewrites a Location or Content-Location value
+func (m *urlMapper) location(value string) string {
+	for _, rule := range m.cfg.Location {
+		if rest, ok := strings.CutPrefix(value, rule.From); ok {
+			return rule.To + rest
+		}
+	}
+
+	if len(m.upstreamHosts) == 0 {
+		return value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return value
+	}
+
+	switch {
+	case u.Host != "" && m.isUpstreamHost(u.Host):
+		// Absolute URLs of the upstream become absolute URLs of the gateway.
+		path, ok := mapPathPrefix(u.EscapedPath(), m.upstreamPrefix, m.publicPrefix)
+		if !ok {
+			path = u.EscapedPath()
+		}
+
+		return m.publicOrigin + withQueryAndFragment(path, u)
+	case u.Host == "" && u.Scheme == "" && strings.HasPrefix(u.Path, "/"):
+		if path, ok := mapPathPrefix(u.EscapedPath(), m.upstreamPrefix, m.publicPrefix); ok {
+			return withQueryAndFragment(path, u)
+		}
+	}
+
+	return value
+}
+
+func (m *urlMapper) isUpstreamHost(host string) bool {
+	for _, upstream := range m.upstreamHosts {
+		if strings.EqualFold(host, upstream) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withQueryAndFragment(path string, u *url.URL) string {
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	if u.Fragment != "" {
+		path += "#" + u.EscapedFragment()
+	}
+
+	return path
+}
+
+// mapPathPrefix replaces the from prefix of path by to, matching whole segments only
+func mapPathPrefix(path, from, to string) (string, bool) {
+	from, to = strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")
+
+	rest, ok := strings.CutPrefix(path, from)
+	if !ok || rest != "" && !strings.HasPrefix(rest, "/") {
+		return path, false
+	}
+
+	if to+rest == "" {
+		return "/", true
+	}
+
+	return to + rest, true
+}
+
+// cookie rewrites the Domain and Path attributes of a Set-Cookie value
+func (m *urlMapper) cookie(value string) string {
+	parts := strings.Split(value, ";")
+	kept := parts[:1]
+	for _, part := range parts[1:] {
+		name, attribute, _ := strings.Cut(strings.TrimSpace(part), "=")
+
+		switch {
+		case strings.EqualFold(name, "Domain"):
+			domain, keep := m.cookieDomain(attribute)
+			if !keep {
+				continue
+			}
+
+			part = " " + name + "=" + domain
+		case strings.EqualFold(name, "Path"):
+			part = " " + name + "=" + m.cookiePath(attribute)
+		}
+
+		kept = append(kept, part)
+	}
+
+	return strings.Join(kept, ";")
+}
+
+// cookieDomain returns the rewritten domain, or false if the attribute must be removed
+func (m *urlMapper) cookieDomain(domain string) (string, bool) {
+	bare := strings.TrimPrefix(domain, ".")
+	for _, rule := range m.cfg.CookieDomain {
+		if strings.EqualFold(bare, strings.TrimPrefix(rule.From, ".")) {
+			return rule.To, rule.To != ""
+		}
+	}
+
+	// Cookies of the upstream host become host-only cookies of the gateway.
+	for _, host := range m.upstreamHosts {
+		if strings.EqualFold(bare, hostname(host)) {
+			return "", false
+		}
+	}
+
+	return domain, true
+}
+
+func (m *urlMapper) cookiePath(path string) string {
+	for _, rule := range m.cfg.CookiePath {
+		if rest, ok := strings.CutPrefix(path, rule.From); ok {
+			return rule.To + rest
+		}
+	}
+
+	if len(m.upstreamHosts) > 0 {
+		if mapped, ok := mapPathPrefix(path, m.upstreamPrefix, m.publicPrefix); ok {
+			return mapped
+		}
+	}
+
+	return path
+}
+
+// hostname returns the host of a host:port value
+func hostname(host string) string {
+	if u, err := url.Parse("//" + host); err == nil {
+		return u.Hostname()
+	}
+
+	return host
+}
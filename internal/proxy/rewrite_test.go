@@ -0,0 +1,134 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyHandler_ResponseRewrite(t *testing.T) {
+	t.Parallel()
+
+	// serve proxies a request to /users to a backend mounted on /api/users answering with header
+	serve := func(t *testing.T, rewrite *config.ResponseRewriteConfig, header func(backend string) http.Header, modify func(req *http.Request)) *httptest.ResponseRecorder {
+		var backendURL string
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, values := range header(backendURL) {
+				w.Header()[name] = values
+			}
+
+			w.WriteHeader(http.StatusFound)
+		}))
+		t.Cleanup(backend.Close)
+		backendURL = backend.URL
+
+		targetURL, err := url.Parse(backend.URL + "/api/users")
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/users", http.MethodGet, &router.Route{
+			Target:          targetURL,
+			Method:          http.MethodGet,
+			ResponseRewrite: rewrite,
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "http://gateway.example.com/users", nil)
+		if modify != nil {
+			modify(req)
+		}
+
+		recorder := httptest.NewRecorder()
+		proxy.NewHandler(mockRouter).ServeHTTP(recorder, req)
+
+		return recorder
+	}
+
+	t.Run("it should map absolute upstream locations to the gateway", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{Auto: true}, func(backend string) http.Header {
+			return http.Header{
+				"Location":         {backend + "/api/users/login?next=%2Fhome#form"},
+				"Content-Location": {backend + "/api/users/42"},
+			}
+		}, nil)
+
+		require.Equal(t, "http://gateway.example.com/users/login?next=%2Fhome#form", rec.Header().Get("Location"))
+		require.Equal(t, "http://gateway.example.com/users/42", rec.Header().Get("Content-Location"))
+	})
+
+	t.Run("it should map relative upstream locations to the endpoint path", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{Auto: true}, func(string) http.Header {
+			return http.Header{"Location": {"/api/users/login"}}
+		}, nil)
+
+		require.Equal(t, "/users/login", rec.Header().Get("Location"))
+	})
+
+	t.Run("it should keep the locations of other hosts and paths", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{Auto: true}, func(string) http.Header {
+			return http.Header{
+				"Location":         {"https://accounts.example.com/login"},
+				"Content-Location": {"/api/usersettings"},
+			}
+		}, nil)
+
+		require.Equal(t, "https://accounts.example.com/login", rec.Header().Get("Location"))
+		require.Equal(t, "/api/usersettings", rec.Header().Get("Content-Location"))
+	})
+
+	t.Run("it should use the forwarded scheme of the client", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{Auto: true}, func(backend string) http.Header {
+			return http.Header{"Location": {backend + "/api/users/login"}}
+		}, func(req *http.Request) {
+			req.Header.Set("X-Forwarded-Proto", "https")
+		})
+
+		require.Equal(t, "https://gateway.example.com/users/login", rec.Header().Get("Location"))
+	})
+
+	t.Run("it should apply the location rules first", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{
+			Auto:     true,
+			Location: []config.RewriteRule{{From: "http://users-svc:8080/", To: "/users/"}},
+		}, func(string) http.Header {
+			return http.Header{"Location": {"http://users-svc:8080/login"}}
+		}, nil)
+
+		require.Equal(t, "/users/login", rec.Header().Get("Location"))
+	})
+
+	t.Run("it should rewrite cookie domains and paths", func(t *testing.T) {
+		rec := serve(t, &config.ResponseRewriteConfig{
+			Auto:         true,
+			CookieDomain: []config.RewriteRule{{From: "users.internal", To: "example.com"}, {From: "legacy.internal"}},
+			CookiePath:   []config.RewriteRule{{From: "/legacy", To: "/users/legacy"}},
+		}, func(string) http.Header {
+			return http.Header{"Set-Cookie": {
+				"session=1; Domain=127.0.0.1; Path=/api/users; HttpOnly",
+				"theme=dark; domain=.users.internal; path=/api/users/settings; Secure",
+				"old=1; Domain=legacy.internal; Path=/legacy/app",
+				"other=1; Domain=example.org; Path=/",
+			}}
+		}, nil)
+
+		require.Equal(t, []string{
+			"session=1; Path=/users; HttpOnly",
+			"theme=dark; domain=example.com; path=/users/settings; Secure",
+			"old=1; Path=/users/legacy/app",
+			"other=1; Domain=example.org; Path=/",
+		}, rec.Header().Values("Set-Cookie"))
+	})
+
+	t.Run("it should not rewrite without configuration", func(t *testing.T) {
+		rec := serve(t, nil, func(backend string) http.Header {
+			return http.Header{"Location": {backend + "/api/users/login"}}
+		}, nil)
+
+		require.Contains(t, rec.Header().Get("Location"), "127.0.0.1")
+	})
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// upgradeType returns the protocol requested through the Upgrade header, if any
+func upgradeType(h http.Header) string {
+	for _, value := range h.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return h.Get("Upgrade")
+			}
+		}
+	}
+
+	return ""
+}
+
+// upgradeAllowed reports whether the route accepts the requested upgrade protocol
+func upgradeAllowed(route *router.Route, protocol string) bool {
+	if route.Upgrade == nil || protocol == "" {
+		return false
+	}
+
+	for _, allowed := range route.Upgrade.Protocols {
+		if strings.EqualFold(allowed, protocol) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// upgradeTracker keeps track of the active upgraded connections
+type upgradeTracker struct {
+	mutex sync.Mutex
+	conns map[*upgradedConn]struct{}
+}
+
+func newUpgradeTracker() *upgradeTracker {
+	return &upgradeTracker{
+		conns: make(map[*upgradedConn]struct{}),
+	}
+}
+
+// track wraps the backend connection of an upgraded request
+func (t *upgradeTracker) track(conn io.ReadWriteCloser, idleTimeout time.Duration) *upgradedConn {
+	c := &upgradedConn{
+		ReadWriteCloser: conn,
+		tracker:         t,
+		idleTimeout:     idleTimeout,
+	}
+	c.touch()
+
+	if idleTimeout > 0 {
+		c.timer.Store(time.AfterFunc(idleTimeout, c.checkIdle))
+	}
+
+	t.mutex.Lock()
+	t.conns[c] = struct{}{}
+	t.mutex.Unlock()
+
+	return c
+}
+
+func (t *upgradeTracker) remove(c *upgradedConn) {
+	t.mutex.Lock()
+	delete(t.conns, c)
+	t.mutex.Unlock()
+}
+
+// active returns the number of active upgraded connections
+func (t *upgradeTracker) active() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return len(t.conns)
+}
+
+// closeAll closes every active upgraded connection
+func (t *upgradeTracker) closeAll() {
+	t.mutex.Lock()
+	conns := make([]*upgradedConn, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.mutex.Unlock()
+
+	for _, c := range conns {
+		c.Close() //nolint:errcheck
+	}
+}
+
+// upgradedConn wraps the backend side of an upgraded connection. Closing it ends
+// the bidirectional copy, which in turn closes the client connection.
+type upgradedConn struct {
+	io.ReadWriteCloser
+	tracker      *upgradeTracker
+	idleTimeout  time.Duration
+	timer        atomic.Pointer[time.Timer]
+	lastActivity atomic.Int64
+	closeOnce    sync.Once
+	closeErr     error
+}
+
+func (c *upgradedConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	c.touch()
+	return n, err
+}
+
+func (c *upgradedConn) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	c.touch()
+	return n, err
+}
+
+func (c *upgradedConn) Close() error {
+	c.closeOnce.Do(func() {
+		if timer := c.timer.Load(); timer != nil {
+			timer.Stop()
+		}
+
+		c.tracker.remove(c)
+		c.closeErr = c.ReadWriteCloser.Close()
+	})
+
+	return c.closeErr
+}
+
+func (c *upgradedConn) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// checkIdle closes the connection once it has been idle for too long, or re-arms the timer
+func (c *upgradedConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+	if idle >= c.idleTimeout {
+		c.Close() //nolint:errcheck
+		return
+	}
+
+	if timer := c.timer.Load(); timer != nil {
+		timer.Reset(c.idleTimeout - idle)
+	}
+}
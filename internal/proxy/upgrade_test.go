@@ -0,0 +1,182 @@
+package proxy_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/proxy"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/stretchr/testify/require"
+)
+
+// newEchoUpgradeServer returns a backend that switches to the requested protocol and echoes bytes back
+func newEchoUpgradeServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.Header.Get("Sec-WebSocket-Key") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		_ = brw.Flush()
+
+		_, _ = io.Copy(conn, brw)
+	}))
+}
+
+// dialUpgrade performs an upgrade handshake against the gateway and returns the raw connection
+func dialUpgrade(t *testing.T, gatewayURL string) (net.Conn, *bufio.Reader) {
+	u, err := url.Parse(gatewayURL)
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: " + u.Host + "\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	return conn, reader
+}
+
+func TestProxyHandler_Upgrade(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should proxy upgraded connections in both directions", func(t *testing.T) {
+		backend := newEchoUpgradeServer(t)
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/ws", http.MethodGet, &router.Route{
+			Target:  targetURL,
+			Method:  http.MethodGet,
+			Upgrade: &config.UpgradeConfig{Protocols: []string{"websocket"}},
+		})
+
+		handler := proxy.NewHandler(mockRouter)
+		gateway := httptest.NewServer(handler)
+		defer gateway.Close()
+
+		conn, reader := dialUpgrade(t, gateway.URL)
+		defer conn.Close() //nolint:errcheck
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+
+		require.Equal(t, 1, handler.ActiveUpgrades())
+
+		handler.CloseUpgrades()
+
+		_, err = reader.ReadByte()
+		require.Error(t, err)
+		require.Eventually(t, func() bool { return handler.ActiveUpgrades() == 0 }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("it should proxy upgraded connections of routes limiting the response size", func(t *testing.T) {
+		backend := newEchoUpgradeServer(t)
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/ws", http.MethodGet, &router.Route{
+			Target:           targetURL,
+			Method:           http.MethodGet,
+			Upgrade:          &config.UpgradeConfig{Protocols: []string{"websocket"}},
+			MaxResponseBytes: 2,
+		})
+
+		gateway := httptest.NewServer(proxy.NewHandler(mockRouter))
+		defer gateway.Close()
+
+		conn, reader := dialUpgrade(t, gateway.URL)
+		defer conn.Close() //nolint:errcheck
+
+		// Upgraded streams are not responses, so they are not bound by the limit.
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(reader, buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf))
+	})
+
+	t.Run("it should close idle upgraded connections", func(t *testing.T) {
+		backend := newEchoUpgradeServer(t)
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/ws", http.MethodGet, &router.Route{
+			Target:  targetURL,
+			Method:  http.MethodGet,
+			Upgrade: &config.UpgradeConfig{Protocols: []string{"websocket"}, IdleTimeout: 50 * time.Millisecond},
+		})
+
+		handler := proxy.NewHandler(mockRouter)
+		gateway := httptest.NewServer(handler)
+		defer gateway.Close()
+
+		conn, reader := dialUpgrade(t, gateway.URL)
+		defer conn.Close() //nolint:errcheck
+
+		_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = reader.ReadByte()
+		require.ErrorIs(t, err, io.EOF)
+		require.Equal(t, 0, handler.ActiveUpgrades())
+	})
+
+	t.Run("it should strip the upgrade headers when the route does not allow upgrades", func(t *testing.T) {
+		backend := newEchoUpgradeServer(t)
+		defer backend.Close()
+
+		targetURL, err := url.Parse(backend.URL)
+		require.NoError(t, err)
+
+		mockRouter := &mockRouter{}
+		mockRouter.addRoute("/ws", http.MethodGet, &router.Route{
+			Target: targetURL,
+			Method: http.MethodGet,
+		})
+
+		handler := proxy.NewHandler(mockRouter)
+
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		require.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
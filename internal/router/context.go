@@ -0,0 +1,16 @@
+package router
+
+import "context"
+
+type routeContextKey struct{}
+
+// WithRoute returns a copy of ctx carrying the route matched for a request
+func WithRoute(ctx context.Context, route *Route) context.Context {
+	return context.WithValue(ctx, routeContextKey{}, route)
+}
+
+// RouteFromContext returns the route matched for a request, if any
+func RouteFromContext(ctx context.Context) (*Route, bool) {
+	route, ok := ctx.Value(routeContextKey{}).(*Route)
+	return route, ok
+}
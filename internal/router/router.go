@@ -1,23 +1,139 @@
 package router
 
 import (
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/headers"
 	"github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/arthurdotwork/heimdall/internal/upstream"
 )
 
+// allowedStatusPattern matches status codes (404) and classes (2xx)
+var allowedStatusPattern = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
 type Route struct {
 	OriginalPath   string
 	Target         *url.URL
 	Method         string
 	Headers        map[string][]string
 	AllowedHeaders []string
-	Middleware     []string          // Middleware names for this route
-	Middlewares    *middleware.Chain // Resolved middleware chain
-	Handler        http.Handler      // Final handler after middleware (now exported)
+	// HeaderMode selects how client headers are forwarded (allowlist or passthrough)
+	HeaderMode string
+	// EssentialHeaders are always forwarded in allowlist mode
+	EssentialHeaders []string
+	// MaxBodyBytes limits the request body size, 0 means unlimited
+	MaxBodyBytes int64
+	// MaxResponseBytes limits the upstream response body size, 0 means unlimited
+	MaxResponseBytes int64
+	// AllowedStatuses and AllowedContentTypes restrict the accepted upstream responses, nil accepts all
+	AllowedStatuses     []string
+	AllowedContentTypes []string
+	// Upgrade enables HTTP Upgrade proxying, nil disables it
+	Upgrade *config.UpgradeConfig
+	// FlushInterval is the interval between response flushes, negative flushes after every write
+	FlushInterval time.Duration
+	// Protocol is the protocol of the endpoint (http, grpc or grpc-web)
+	Protocol string
+	// SocketPath is the Unix domain socket of unix:// targets
+	SocketPath string
+	// Transport is the round tripper used to reach the target, nil uses the default transport
+	Transport http.RoundTripper
+	// Upstream is the pool of hosts the target is balanced over, nil uses the target host
+	Upstream *upstream.Pool
+	// Errors renders the errors generated by the gateway for the route
+	Errors *problem.Renderer
+	// Aggregate fans the request out to the parts of an aggregation endpoint, nil proxies the request
+	Aggregate *Aggregate
+	// Fallback receives the requests failed by the target, nil disables it
+	Fallback *Fallback
+	// Cache overrides the cache middleware for the route, nil uses its defaults
+	Cache *config.CacheConfig
+	// Coalesce shares one upstream call between concurrent requests with the same method,
+	// URL and CoalesceVary headers, buffering its response up to MaxResponseBytes or 10 MiB
+	Coalesce     bool
+	CoalesceVary []string
+	// PreserveHost forwards the Host header of the client, HostOverride a fixed one,
+	// instead of the target host
+	PreserveHost bool
+	HostOverride string
+	// ResponseRewrite maps upstream URLs in response headers to the gateway, nil disables it
+	ResponseRewrite *config.ResponseRewriteConfig
+	// RequestHeaders and ResponseHeaders are the compiled header policies of the endpoint
+	RequestHeaders  *headers.Policy
+	ResponseHeaders *headers.Policy
+	Middleware      []string          // Middleware names for this route
+	Middlewares     *middleware.Chain // Resolved middleware chain
+	Handler         http.Handler      // Final handler after middleware (now exported)
+
+	// segments of paths with {param} segments, nil for static paths
+	segments []string
+}
+
+// Aggregate is the compiled configuration of an aggregation endpoint
+type Aggregate struct {
+	Parts   []AggregatePart
+	OnError string
+}
+
+// Fallback is the target receiving the requests failed by the target of a route
+type Fallback struct {
+	// Target path and query may contain {param} placeholders
+	Target *url.URL
+	// Statuses of the target sending the request to the fallback, as codes or classes
+	Statuses  []string
+	Transport http.RoundTripper
+}
+
+// AggregatePart is a sub-request of an aggregation endpoint
+type AggregatePart struct {
+	Name string
+	// Target path and query may contain {param} placeholders
+	Target    *url.URL
+	Method    string
+	Headers   map[string][]string
+	Timeout   time.Duration
+	Transport http.RoundTripper
+}
+
+// PathValues returns the values of the {param} segments of the route path in path,
+// or nil if the route has none or path does not match.
+func (r *Route) PathValues(path string) map[string]string {
+	if r.segments == nil {
+		return nil
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != len(r.segments) {
+		return nil
+	}
+
+	values := make(map[string]string)
+	for i, segment := range r.segments {
+		if name, ok := strings.CutPrefix(segment, "{"); ok && strings.HasSuffix(name, "}") {
+			if parts[i] == "" {
+				return nil
+			}
+
+			values[strings.TrimSuffix(name, "}")] = parts[i]
+			continue
+		}
+
+		if segment != parts[i] {
+			return nil
+		}
+	}
+
+	return values
 }
 
 type Router struct {
@@ -25,6 +141,8 @@ type Router struct {
 	Routes map[string]map[string]*Route
 	// Registry for middleware
 	registry *middleware.Registry
+	// patterns are the routes whose path has {param} segments
+	patterns []*Route
 }
 
 func New(endpoints []config.EndpointConfig) (*Router, error) {
@@ -33,31 +151,176 @@ func New(endpoints []config.EndpointConfig) (*Router, error) {
 
 func NewWithRegistry(endpoints []config.EndpointConfig, registry *middleware.Registry) (*Router, error) {
 	routes := make(map[string]map[string]*Route)
+	var patterns, grpcWebRoutes []*Route
 
 	for _, endpoint := range endpoints {
-		targetURL, err := url.Parse(endpoint.Target)
+		targetURL, socketPath, err := parseTarget(endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		switch endpoint.Protocol {
+		case "", config.ProtocolHTTP, config.ProtocolGRPC, config.ProtocolGRPCWeb:
+		default:
+			return nil, fmt.Errorf("invalid protocol %q for endpoint %s", endpoint.Protocol, endpoint.Path)
+		}
+
+		rt, err := transport.New(endpoint, targetURL, socketPath)
+		if err != nil {
+			return nil, err
+		}
+
+		switch endpoint.HeaderMode {
+		case "", config.HeaderModeAllowlist, config.HeaderModePassthrough:
+		default:
+			return nil, fmt.Errorf("invalid header mode %q for endpoint %s", endpoint.HeaderMode, endpoint.Path)
+		}
+
+		if endpoint.MaxBodyBytes < -1 {
+			return nil, fmt.Errorf("invalid max body bytes %d for endpoint %s, expected a size or -1 for unlimited", endpoint.MaxBodyBytes, endpoint.Path)
+		}
+
+		for _, status := range endpoint.AllowedStatuses {
+			if !allowedStatusPattern.MatchString(status) {
+				return nil, fmt.Errorf("invalid allowed status %q for endpoint %s, expected a code or a class such as 2xx", status, endpoint.Path)
+			}
+		}
+
+		if endpoint.PreserveHost && endpoint.HostOverride != "" {
+			return nil, fmt.Errorf("preserve_host and host_override are mutually exclusive for endpoint %s", endpoint.Path)
+		}
+
+		// Scripts are sent the Host of the client, which they use as SERVER_NAME to build URLs,
+		// rather than the placeholder host of their target.
+		preserveHost := endpoint.PreserveHost
+		if strings.HasPrefix(endpoint.Target, "cgi:") || strings.HasPrefix(endpoint.Target, "fastcgi:") {
+			preserveHost = endpoint.HostOverride == ""
+		}
+
+		requestHeaders, err := headers.NewPolicy(endpoint.RequestHeaders)
+		if err != nil {
+			return nil, err
+		}
+
+		responseHeaders, err := headers.NewPolicy(endpoint.ResponseHeaders)
 		if err != nil {
 			return nil, err
 		}
 
+		errorRenderer, err := problem.NewRenderer(endpoint.Errors)
+		if err != nil {
+			return nil, err
+		}
+
+		var aggregate *Aggregate
+		if endpoint.Aggregate != nil {
+			aggregate, err = newAggregate(endpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var pool *upstream.Pool
+		if endpoint.Upstream != nil {
+			pool, err = upstream.New(*endpoint.Upstream, targetURL)
+			if err != nil {
+				return nil, fmt.Errorf("invalid upstream for endpoint %s: %w", endpoint.Path, err)
+			}
+		}
+
+		if endpoint.Hedge != nil && rt != nil {
+			if err := validateHedge(*endpoint.Hedge); err != nil {
+				return nil, fmt.Errorf("invalid hedge for endpoint %s: %w", endpoint.Path, err)
+			}
+
+			// The hedged request goes to another host of the pool, if any.
+			var targets transport.Targets
+			if pool != nil {
+				targets = pool
+			}
+
+			rt = transport.NewHedging(rt, *endpoint.Hedge, targets)
+		}
+
+		var fallback *Fallback
+		if endpoint.Fallback != nil {
+			fallback, err = newFallback(endpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		if _, ok := routes[endpoint.Path]; !ok {
 			routes[endpoint.Path] = make(map[string]*Route)
 		}
 
-		routes[endpoint.Path][endpoint.Method] = &Route{
-			OriginalPath:   endpoint.Path,
-			Target:         targetURL,
-			Method:         endpoint.Method,
-			Headers:        endpoint.Headers,
-			AllowedHeaders: endpoint.AllowedHeaders,
-			Middleware:     endpoint.Middlewares,
-			Middlewares:    middleware.NewChain(),
+		route := &Route{
+			OriginalPath:        endpoint.Path,
+			Target:              targetURL,
+			Method:              endpoint.Method,
+			Headers:             endpoint.Headers,
+			AllowedHeaders:      endpoint.AllowedHeaders,
+			HeaderMode:          endpoint.HeaderMode,
+			EssentialHeaders:    endpoint.EssentialHeaders,
+			MaxBodyBytes:        max(endpoint.MaxBodyBytes, 0),
+			MaxResponseBytes:    endpoint.MaxResponseBytes,
+			AllowedStatuses:     endpoint.AllowedStatuses,
+			AllowedContentTypes: endpoint.AllowedContentTypes,
+			Upgrade:             endpoint.Upgrade,
+			FlushInterval:       time.Duration(endpoint.FlushInterval),
+			Protocol:            endpoint.Protocol,
+			SocketPath:          socketPath,
+			Transport:           rt,
+			Upstream:            pool,
+			Errors:              errorRenderer,
+			Aggregate:           aggregate,
+			Fallback:            fallback,
+			Cache:               endpoint.Cache,
+			Coalesce:            endpoint.Coalesce,
+			CoalesceVary:        endpoint.CoalesceVary,
+			PreserveHost:        preserveHost,
+			HostOverride:        endpoint.HostOverride,
+			ResponseRewrite:     endpoint.ResponseRewrite,
+			RequestHeaders:      requestHeaders,
+			ResponseHeaders:     responseHeaders,
+			Middleware:          endpoint.Middlewares,
+			Middlewares:         middleware.NewChain(),
+		}
+		if strings.Contains(endpoint.Path, "{") {
+			route.segments = strings.Split(endpoint.Path, "/")
+		}
+		routes[endpoint.Path][endpoint.Method] = route
+
+		if endpoint.Protocol == config.ProtocolGRPCWeb {
+			grpcWebRoutes = append(grpcWebRoutes, route)
+		}
+
+		// Paths with {param} segments are matched in the order of the endpoints.
+		if route.segments != nil {
+			patterns = append(patterns, route)
+		}
+	}
+
+	// Browsers send CORS preflight requests before gRPC-Web calls, answered by the gRPC-Web
+	// route unless an OPTIONS endpoint is configured for the path.
+	for _, route := range grpcWebRoutes {
+		if _, exists := routes[route.OriginalPath][http.MethodOptions]; exists {
+			continue
+		}
+
+		preflight := *route
+		preflight.Method = http.MethodOptions
+		preflight.Middlewares = middleware.NewChain()
+		routes[route.OriginalPath][http.MethodOptions] = &preflight
+		if preflight.segments != nil {
+			patterns = append(patterns, &preflight)
 		}
 	}
 
 	router := &Router{
 		Routes:   routes,
 		registry: registry,
+		patterns: patterns,
 	}
 
 	// Initialize middleware for each route
@@ -82,18 +345,211 @@ func NewWithRegistry(endpoints []config.EndpointConfig, registry *middleware.Reg
 	return router, nil
 }
 
+// defaultFallbackStatuses are the statuses of the target sending requests to the fallback by default
+var defaultFallbackStatuses = []string{"502", "503", "504"}
+
+// newFallback builds the fallback of an endpoint, which shares the egress proxy of the endpoint
+func newFallback(endpoint config.EndpointConfig) (*Fallback, error) {
+	if endpoint.Aggregate != nil || config.IsGRPC(endpoint.Protocol) || strings.HasPrefix(endpoint.Target, "handler:") {
+		return nil, fmt.Errorf("fallback is not supported for aggregate, gRPC and handler endpoint %s", endpoint.Path)
+	}
+
+	targetURL, err := url.Parse(endpoint.Fallback.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fallback target for endpoint %s: %w", endpoint.Path, err)
+	}
+
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" || targetURL.Host == "" {
+		return nil, fmt.Errorf("invalid fallback target %q for endpoint %s, expected an http or https URL", endpoint.Fallback.Target, endpoint.Path)
+	}
+
+	statuses := endpoint.Fallback.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultFallbackStatuses
+	}
+
+	for _, status := range statuses {
+		if !allowedStatusPattern.MatchString(status) {
+			return nil, fmt.Errorf("invalid fallback status %q for endpoint %s, expected a code or a class such as 5xx", status, endpoint.Path)
+		}
+	}
+
+	// The TLS settings, host override and upstream pool of the endpoint are the ones of its target.
+	fallbackEndpoint := endpoint
+	fallbackEndpoint.Target = endpoint.Fallback.Target
+	fallbackEndpoint.TLS = nil
+	fallbackEndpoint.HostOverride = ""
+	fallbackEndpoint.Upstream = nil
+
+	rt, err := transport.New(fallbackEndpoint, targetURL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Fallback{Target: targetURL, Statuses: statuses, Transport: rt}, nil
+}
+
+// validateHedge checks that the hedge delay is known and the budget is a ratio
+func validateHedge(cfg config.HedgeConfig) error {
+	switch {
+	case cfg.Delay < 0:
+		return fmt.Errorf("negative delay %s", cfg.Delay)
+	case cfg.Percentile < 0 || cfg.Percentile >= 100:
+		return fmt.Errorf("percentile %v out of range, expected a value between 0 and 100", cfg.Percentile)
+	case cfg.Delay == 0 && cfg.Percentile == 0:
+		return fmt.Errorf("a delay or a percentile is required")
+	case cfg.Budget < 0 || cfg.Budget > 1:
+		return fmt.Errorf("budget %v out of range, expected a ratio between 0 and 1", cfg.Budget)
+	}
+
+	return nil
+}
+
+// newAggregate compiles the parts of an aggregation endpoint. Parts share the transport
+// settings of the endpoint, such as TLS.
+func newAggregate(endpoint config.EndpointConfig) (*Aggregate, error) {
+	switch endpoint.Aggregate.OnError {
+	case "", config.AggregateOnErrorFail, config.AggregateOnErrorNull:
+	default:
+		return nil, fmt.Errorf("invalid on_error policy %q for endpoint %s", endpoint.Aggregate.OnError, endpoint.Path)
+	}
+
+	if len(endpoint.Aggregate.Parts) == 0 {
+		return nil, fmt.Errorf("aggregate endpoint %s has no parts", endpoint.Path)
+	}
+
+	aggregate := &Aggregate{OnError: endpoint.Aggregate.OnError}
+	names := make(map[string]struct{})
+	for _, part := range endpoint.Aggregate.Parts {
+		if part.Name == "" {
+			return nil, fmt.Errorf("aggregate part of endpoint %s has no name", endpoint.Path)
+		}
+
+		if _, exists := names[part.Name]; exists {
+			return nil, fmt.Errorf("duplicate aggregate part %q for endpoint %s", part.Name, endpoint.Path)
+		}
+		names[part.Name] = struct{}{}
+
+		partEndpoint := endpoint
+		partEndpoint.Target = part.Target
+
+		targetURL, socketPath, err := parseTarget(partEndpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		if targetURL.Scheme == "handler" {
+			return nil, fmt.Errorf("handler target of aggregate part %q is not supported for endpoint %s", part.Name, endpoint.Path)
+		}
+
+		rt, err := transport.New(partEndpoint, targetURL, socketPath)
+		if err != nil {
+			return nil, err
+		}
+
+		aggregate.Parts = append(aggregate.Parts, AggregatePart{
+			Name:      part.Name,
+			Target:    targetURL,
+			Method:    part.Method,
+			Headers:   part.Headers,
+			Timeout:   part.Timeout,
+			Transport: rt,
+		})
+	}
+
+	return aggregate, nil
+}
+
+// parseTarget parses the target of an endpoint. Unix domain socket targets are written
+// unix:///path/to/app.sock:/request/path and are reached over HTTP through the socket.
+// cgi:// and fastcgi:// targets keep the endpoint path, their script is run by the transport.
+// handler://name targets are served by the in-process handler registered under name.
+func parseTarget(endpoint config.EndpointConfig) (*url.URL, string, error) {
+	target := endpoint.Target
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch targetURL.Scheme {
+	case "unix":
+	case "cgi", "fastcgi":
+		return &url.URL{Scheme: "http", Host: "localhost", Path: endpoint.Path}, "", nil
+	case "handler":
+		if targetURL.Host == "" {
+			return nil, "", fmt.Errorf("missing handler name in target %q", target)
+		}
+
+		return targetURL, "", nil
+	default:
+		return targetURL, "", nil
+	}
+
+	socketPath, path, _ := strings.Cut(targetURL.Path, ":")
+	if targetURL.Host != "" || socketPath == "" {
+		return nil, "", fmt.Errorf("invalid unix target %q, expected unix:///path/to/app.sock:/path", target)
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	return &url.URL{
+		Scheme:   "http",
+		Host:     "localhost",
+		Path:     path,
+		RawQuery: targetURL.RawQuery,
+	}, socketPath, nil
+}
+
 func (r *Router) GetRoute(path string, method string) (*Route, bool) {
-	methodRoutes, ok := r.Routes[path]
-	if !ok {
-		return nil, false
+	if route, ok := r.Routes[path][method]; ok {
+		return route, true
 	}
 
-	route, ok := methodRoutes[method]
-	if !ok {
-		return nil, false
+	// gRPC endpoints declared as "/package.Service/" match every method of the service.
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		if route, ok := r.Routes[path[:i+1]][method]; ok && config.IsGRPC(route.Protocol) {
+			return route, true
+		}
+	}
+
+	for _, route := range r.patterns {
+		if route.Method == method && route.PathValues(path) != nil {
+			return route, true
+		}
+	}
+
+	return nil, false
+}
+
+// Pools returns the upstream pools of the routes
+func (r *Router) Pools() []*upstream.Pool {
+	var pools []*upstream.Pool
+	for _, methodRoutes := range r.Routes {
+		for _, route := range methodRoutes {
+			if route.Upstream != nil && !slices.Contains(pools, route.Upstream) {
+				pools = append(pools, route.Upstream)
+			}
+		}
+	}
+
+	return pools
+}
+
+// Handlers returns the names of the in-process handlers targeted by the routes, sorted
+func (r *Router) Handlers() []string {
+	var names []string
+	for _, methodRoutes := range r.Routes {
+		for _, route := range methodRoutes {
+			if route.Target.Scheme == "handler" && !slices.Contains(names, route.Target.Host) {
+				names = append(names, route.Target.Host)
+			}
+		}
 	}
 
-	return route, true
+	slices.Sort(names)
+	return names
 }
 
 // SetHandler sets the final handler for a route after applying its middleware
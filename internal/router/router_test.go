@@ -3,7 +3,9 @@ package router_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/arthurdotwork/heimdall/internal/config"
 	"github.com/arthurdotwork/heimdall/internal/middleware"
@@ -21,6 +23,106 @@ func TestNewRouter(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	t.Run("it should return an error if the header mode is invalid", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", HeaderMode: "invalid"}}
+
+		_, err := router.New(endpoints)
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error if the protocol is invalid", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", Protocol: "ftp"}}
+
+		_, err := router.New(endpoints)
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error if an allowed status is invalid", func(t *testing.T) {
+		for _, status := range []string{"2XX", "600", "20x", "ok"} {
+			endpoints := []config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", AllowedStatuses: []string{"2xx", status}}}
+
+			_, err := router.New(endpoints)
+			require.Error(t, err, status)
+		}
+	})
+
+	t.Run("it should lift the body size limit of endpoints opting out of it", func(t *testing.T) {
+		r, err := router.New([]config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", MaxBodyBytes: -1}})
+		require.NoError(t, err)
+
+		route, ok := r.GetRoute("/", http.MethodGet)
+		require.True(t, ok)
+		require.Zero(t, route.MaxBodyBytes)
+
+		_, err = router.New([]config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", MaxBodyBytes: -2}})
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error if the host is both preserved and overridden", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", PreserveHost: true, HostOverride: "api.internal"}}
+
+		_, err := router.New(endpoints)
+		require.Error(t, err)
+	})
+
+	t.Run("it should build the fallback of the endpoints", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{Path: "/", Target: "http://primary/", Method: "GET", Fallback: &config.FallbackConfig{Target: "https://replica/items"}}}
+
+		router, err := router.New(endpoints)
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/", "GET")
+		require.True(t, ok)
+		require.Equal(t, "replica", route.Fallback.Target.Host)
+		require.Equal(t, []string{"502", "503", "504"}, route.Fallback.Statuses)
+		require.NotNil(t, route.Fallback.Transport)
+	})
+
+	t.Run("it should not share the TLS, host and upstream settings of the target with the fallback", func(t *testing.T) {
+		router, err := router.New([]config.EndpointConfig{{
+			Path:         "/",
+			Target:       "https://primary/",
+			Method:       "GET",
+			TLS:          &config.TLSConfig{ServerName: "primary.internal"},
+			HostOverride: "api.internal",
+			Upstream:     &config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: "dns", Name: "primary"}},
+			Fallback:     &config.FallbackConfig{Target: "https://replica/items"},
+		}})
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/", "GET")
+		require.True(t, ok)
+		require.IsType(t, &http.Transport{}, route.Fallback.Transport)
+
+		tlsConfig := route.Fallback.Transport.(*http.Transport).TLSClientConfig
+		if tlsConfig != nil {
+			require.Empty(t, tlsConfig.ServerName)
+		}
+	})
+
+	t.Run("it should return an error for invalid fallbacks", func(t *testing.T) {
+		for _, endpoint := range []config.EndpointConfig{
+			{Path: "/", Target: "http://primary/", Method: "GET", Fallback: &config.FallbackConfig{Target: "replica"}},
+			{Path: "/", Target: "http://primary/", Method: "GET", Fallback: &config.FallbackConfig{Target: "http://replica", Statuses: []string{"5XX"}}},
+			{Path: "/", Target: "http://primary/", Method: "POST", Protocol: config.ProtocolGRPC, Fallback: &config.FallbackConfig{Target: "http://replica"}},
+		} {
+			_, err := router.New([]config.EndpointConfig{endpoint})
+			require.Error(t, err)
+		}
+	})
+
+	t.Run("it should return an error for invalid hedge configurations", func(t *testing.T) {
+		for _, hedge := range []*config.HedgeConfig{
+			{},
+			{Delay: -time.Millisecond},
+			{Percentile: 100},
+			{Delay: time.Millisecond, Budget: 2},
+		} {
+			_, err := router.New([]config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET", Hedge: hedge}})
+			require.Error(t, err)
+		}
+	})
+
 	t.Run("it should build the router", func(t *testing.T) {
 		endpoints := []config.EndpointConfig{{Path: "/", Target: "https://www.google.com/", Method: "GET"}}
 
@@ -34,6 +136,152 @@ func TestNewRouter(t *testing.T) {
 		require.Equal(t, "https://www.google.com/", router.Routes["/"]["GET"].Target.String())
 	})
 
+	t.Run("it should accept preflight requests on gRPC-Web endpoints", func(t *testing.T) {
+		router, err := router.New([]config.EndpointConfig{
+			{Path: "/helloworld.Greeter/", Target: "http://greeter:50051", Method: "POST", Protocol: config.ProtocolGRPCWeb},
+		})
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/helloworld.Greeter/SayHello", "OPTIONS")
+		require.True(t, ok)
+		require.Equal(t, "OPTIONS", route.Method)
+		require.Equal(t, config.ProtocolGRPCWeb, route.Protocol)
+	})
+
+	t.Run("it should keep the OPTIONS endpoint configured on the path of a gRPC-Web endpoint", func(t *testing.T) {
+		for _, endpoints := range [][]config.EndpointConfig{
+			{
+				{Path: "/web/", Target: "http://greeter:50051", Method: "POST", Protocol: config.ProtocolGRPCWeb},
+				{Path: "/web/", Target: "http://options", Method: "OPTIONS"},
+			},
+			{
+				{Path: "/web/", Target: "http://options", Method: "OPTIONS"},
+				{Path: "/web/", Target: "http://greeter:50051", Method: "POST", Protocol: config.ProtocolGRPCWeb},
+			},
+		} {
+			router, err := router.New(endpoints)
+			require.NoError(t, err)
+
+			route, ok := router.GetRoute("/web/", "OPTIONS")
+			require.True(t, ok)
+			require.Equal(t, "options", route.Target.Host)
+		}
+	})
+
+	t.Run("it should parse unix socket targets", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{Path: "/", Target: "unix:///var/run/app.sock:/api/v1?debug=1", Method: "GET"}}
+
+		router, err := router.New(endpoints)
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/", "GET")
+		require.True(t, ok)
+		require.Equal(t, "/var/run/app.sock", route.SocketPath)
+		require.Equal(t, "http://localhost/api/v1?debug=1", route.Target.String())
+	})
+
+	t.Run("it should return an error if the unix socket path is missing", func(t *testing.T) {
+		_, err := router.New([]config.EndpointConfig{{Path: "/", Target: "unix://:/api", Method: "GET"}})
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error if the handler name is missing", func(t *testing.T) {
+		_, err := router.New([]config.EndpointConfig{{Path: "/", Target: "handler://", Method: "GET"}})
+		require.Error(t, err)
+	})
+
+	t.Run("it should build the upstream pools of the endpoints", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{
+			{Path: "/api", Target: "http://api.internal/v1", Method: "GET", Upstream: &config.UpstreamConfig{Targets: []string{"10.0.0.1:80"}}},
+			{Path: "/web", Target: "http://api.internal", Method: "POST", Protocol: config.ProtocolGRPCWeb, Upstream: &config.UpstreamConfig{Targets: []string{"10.0.0.2:80"}}},
+		}
+
+		router, err := router.New(endpoints)
+		require.NoError(t, err)
+		require.Len(t, router.Pools(), 2)
+
+		route, ok := router.GetRoute("/api", "GET")
+		require.True(t, ok)
+		require.Equal(t, "10.0.0.1:80", route.Upstream.Targets()[0].Host)
+	})
+
+	t.Run("it should list the handlers targeted by the routes", func(t *testing.T) {
+		router, err := router.New([]config.EndpointConfig{
+			{Path: "/whoami", Target: "handler://whoami", Method: "GET"},
+			{Path: "/whoami", Target: "handler://whoami", Method: "POST"},
+			{Path: "/health", Target: "handler://health", Method: "GET"},
+			{Path: "/api", Target: "http://api.internal", Method: "GET"},
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"health", "whoami"}, router.Handlers())
+	})
+
+	t.Run("it should return an error for invalid upstreams", func(t *testing.T) {
+		_, err := router.New([]config.EndpointConfig{{Path: "/", Target: "http://api.internal", Method: "GET", Upstream: &config.UpstreamConfig{Targets: []string{"::"}}}})
+		require.Error(t, err)
+	})
+
+	t.Run("it should match paths with parameters", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{
+			{Path: "/users/me", Target: "http://users/me", Method: "GET"},
+			{Path: "/users/{id}/orders/{orderID}", Target: "http://orders/{orderID}", Method: "GET"},
+		}
+
+		router, err := router.New(endpoints)
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/users/me", "GET")
+		require.True(t, ok)
+		require.Equal(t, "/users/me", route.OriginalPath)
+		require.Nil(t, route.PathValues("/users/me"))
+
+		route, ok = router.GetRoute("/users/42/orders/7", "GET")
+		require.True(t, ok)
+		require.Equal(t, map[string]string{"id": "42", "orderID": "7"}, route.PathValues("/users/42/orders/7"))
+
+		_, ok = router.GetRoute("/users/42/orders/", "GET")
+		require.False(t, ok)
+
+		_, ok = router.GetRoute("/users/42/orders/7", "POST")
+		require.False(t, ok)
+	})
+
+	t.Run("it should build the parts of aggregate endpoints", func(t *testing.T) {
+		endpoints := []config.EndpointConfig{{
+			Path:   "/screens/home/{id}",
+			Method: "GET",
+			Aggregate: &config.AggregateConfig{
+				OnError: config.AggregateOnErrorNull,
+				Parts: []config.AggregatePartConfig{
+					{Name: "profile", Target: "http://users/users/{id}", Method: "GET"},
+				},
+			},
+		}}
+
+		router, err := router.New(endpoints)
+		require.NoError(t, err)
+
+		route, ok := router.GetRoute("/screens/home/42", "GET")
+		require.True(t, ok)
+		require.Equal(t, config.AggregateOnErrorNull, route.Aggregate.OnError)
+		require.Len(t, route.Aggregate.Parts, 1)
+		require.Equal(t, "/users/{id}", route.Aggregate.Parts[0].Target.Path)
+		require.NotNil(t, route.Aggregate.Parts[0].Transport)
+	})
+
+	t.Run("it should return an error for invalid aggregate endpoints", func(t *testing.T) {
+		for _, aggregate := range []*config.AggregateConfig{
+			{},
+			{OnError: "retry", Parts: []config.AggregatePartConfig{{Name: "a", Target: "http://a"}}},
+			{Parts: []config.AggregatePartConfig{{Target: "http://a"}}},
+			{Parts: []config.AggregatePartConfig{{Name: "a", Target: "http://a"}, {Name: "a", Target: "http://b"}}},
+			{Parts: []config.AggregatePartConfig{{Name: "a", Target: "handler://whoami"}}},
+		} {
+			_, err := router.New([]config.EndpointConfig{{Path: "/", Method: "GET", Aggregate: aggregate}})
+			require.Error(t, err)
+		}
+	})
+
 	t.Run("it should build router with middleware", func(t *testing.T) {
 		middleware.ResetDefaultRegistry()
 
@@ -93,6 +341,38 @@ func TestRouter_GetRoute(t *testing.T) {
 	})
 }
 
+func TestRouter_GetRoute_GRPC(t *testing.T) {
+	t.Parallel()
+
+	endpoints := []config.EndpointConfig{
+		{Path: "/helloworld.Greeter/", Target: "http://greeter:50051", Method: "POST", Protocol: config.ProtocolGRPC},
+		{Path: "/other.Service/Call", Target: "http://other:50051", Method: "POST", Protocol: config.ProtocolGRPC},
+		{Path: "/http/", Target: "http://backend", Method: "POST"},
+	}
+	router, err := router.New(endpoints)
+	require.NoError(t, err)
+
+	t.Run("it should match every method of a gRPC service", func(t *testing.T) {
+		route, ok := router.GetRoute("/helloworld.Greeter/SayHello", "POST")
+		require.True(t, ok)
+		require.Equal(t, "/helloworld.Greeter/", route.OriginalPath)
+		require.NotNil(t, route.Transport)
+	})
+
+	t.Run("it should match a single gRPC method", func(t *testing.T) {
+		_, ok := router.GetRoute("/other.Service/Call", "POST")
+		require.True(t, ok)
+
+		_, ok = router.GetRoute("/other.Service/Other", "POST")
+		require.False(t, ok)
+	})
+
+	t.Run("it should not match prefixes of plain HTTP endpoints", func(t *testing.T) {
+		_, ok := router.GetRoute("/http/anything", "POST")
+		require.False(t, ok)
+	})
+}
+
 func TestRouter_ApplyGlobalMiddleware(t *testing.T) {
 	t.Parallel()
 
@@ -197,6 +477,34 @@ func TestRouter_ApplyGlobalMiddleware(t *testing.T) {
 		require.Equal(t, "true", rec2.Header().Get("X-Global"))
 		require.Empty(t, rec2.Header().Get("X-Route"))
 	})
+
+	t.Run("it should run the middleware of gRPC-Web endpoints once per request", func(t *testing.T) {
+		middleware.ResetDefaultRegistry()
+
+		var calls atomic.Int32
+		_ = middleware.RegisterMiddleware("counting", middleware.Func(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				next.ServeHTTP(w, r)
+			})
+		}))
+
+		router, err := router.New([]config.EndpointConfig{
+			{Path: "/helloworld.Greeter/", Target: "http://greeter:50051", Method: "POST", Protocol: config.ProtocolGRPCWeb, Middlewares: []string{"counting"}},
+		})
+		require.NoError(t, err)
+
+		router.ApplyGlobalMiddleware(middleware.NewChain(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		for _, method := range []string{http.MethodPost, http.MethodOptions} {
+			route, ok := router.GetRoute("/helloworld.Greeter/SayHello", method)
+			require.True(t, ok)
+
+			calls.Store(0)
+			route.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/helloworld.Greeter/SayHello", nil))
+			require.Equal(t, int32(1), calls.Load(), method)
+		}
+	})
 }
 
 func TestRouter_SetHandler(t *testing.T) {
@@ -17,6 +17,7 @@ type Server struct {
 	cfg         config.GatewayConfig
 	handler     http.Handler
 	middlewares *middleware.Chain
+	onShutdown  []func()
 }
 
 func New(cfg config.GatewayConfig, handler http.Handler) *Server {
@@ -27,6 +28,12 @@ func New(cfg config.GatewayConfig, handler http.Handler) *Server {
 	}
 }
 
+// RegisterOnShutdown registers a function to call when the server shuts down,
+// e.g. to close hijacked connections that the graceful shutdown does not track.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.onShutdown = append(s.onShutdown, f)
+}
+
 func (s *Server) Start(ctx context.Context) error {
 	reqCtx, cancelRequest := context.WithCancel(context.Background())
 
@@ -44,6 +51,18 @@ func (s *Server) Start(ctx context.Context) error {
 		},
 	}
 
+	// Accept HTTP/2 over cleartext connections (h2c), as required by gRPC clients.
+	if s.cfg.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = protocols
+	}
+
+	for _, f := range s.onShutdown {
+		srv.RegisterOnShutdown(f)
+	}
+
 	gr, ctx := errgroup.WithContext(ctx)
 
 	gr.Go(func() error {
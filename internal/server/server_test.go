@@ -62,6 +62,37 @@ func TestServer_Start(t *testing.T) {
 		require.Less(t, duration, 1*time.Second, "server should exit quickly with canceled context")
 	})
 
+	t.Run("it should accept HTTP/2 over cleartext when h2c is enabled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Proto", r.Proto)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		server := server.New(config.GatewayConfig{Port: 8084, H2C: true, ShutdownTimeout: time.Second}, handler)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- server.Start(ctx)
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		client := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 2 * time.Second}
+
+		resp, err := client.Get("http://localhost:8084")
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+		require.Equal(t, "HTTP/2.0", resp.Header.Get("X-Proto"))
+
+		cancel()
+		require.NoError(t, <-done)
+	})
+
 	t.Run("it should handle abrupt shutdown with long-running requests", func(t *testing.T) {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
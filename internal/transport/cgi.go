@@ -0,0 +1,146 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/fastcgi"
+)
+
+// newCGITransport runs the script of a cgi:///path/to/script target for every request.
+// The endpoint path is the SCRIPT_NAME of the script.
+func newCGITransport(upstream *url.URL, root string) (http.RoundTripper, error) {
+	if upstream.Host != "" || upstream.Path == "" {
+		return nil, fmt.Errorf("invalid cgi target %q, expected cgi:///path/to/script", upstream)
+	}
+
+	return &cgiTransport{path: upstream.Path, root: root}, nil
+}
+
+// cgiTransport runs a CGI script with the port the request was received on as SERVER_PORT
+type cgiTransport struct {
+	path string
+	root string
+}
+
+func (t *cgiTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	handler := &cgi.Handler{Path: t.path, Root: t.root, Env: []string{"SERVER_PORT=" + serverPort(req)}}
+	return (&handlerTransport{handler: handler}).RoundTrip(req)
+}
+
+// serverPort returns the port of the gateway listener the request was received on, or the
+// port of its Host header when it is not known
+func serverPort(req *http.Request) string {
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			return port
+		}
+	}
+
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		return port
+	}
+
+	return "80"
+}
+
+// newFastCGITransport sends requests to the responder of a fastcgi://host:port/path/to/script
+// target, or fastcgi:///path/to/app.sock:/path/to/script for Unix domain sockets.
+// Chunked request bodies are buffered up to maxBodyBytes, the default limit if 0.
+func newFastCGITransport(upstream *url.URL, root string, maxBodyBytes int64) (http.RoundTripper, error) {
+	t := &fastcgi.Transport{
+		Network:        "tcp",
+		Address:        upstream.Host,
+		ScriptFilename: upstream.Path,
+		Root:           root,
+		DialTimeout:    30 * time.Second,
+		// Bodies exceeding the limit of the endpoint are rejected with a 413 while being buffered.
+		MaxBufferedBody: maxBodyBytes,
+	}
+
+	if upstream.Host == "" {
+		t.Network = "unix"
+		t.Address, t.ScriptFilename, _ = strings.Cut(upstream.Path, ":")
+	}
+
+	if t.Address == "" || t.ScriptFilename == "" {
+		return nil, fmt.Errorf("invalid fastcgi target %q, expected fastcgi://host:port/path/to/script", upstream)
+	}
+
+	return t, nil
+}
+
+// handlerTransport is a round tripper serving requests with an in-process handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, pw := io.Pipe()
+	rw := &pipeResponseWriter{
+		req:    req,
+		header: make(http.Header),
+		body:   body,
+		pw:     pw,
+		ready:  make(chan *http.Response, 1),
+	}
+
+	go func() {
+		t.handler.ServeHTTP(rw, req)
+		rw.WriteHeader(http.StatusOK)
+		pw.Close() //nolint:errcheck
+	}()
+
+	select {
+	case resp := <-rw.ready:
+		return resp, nil
+	case <-req.Context().Done():
+		pw.CloseWithError(req.Context().Err()) //nolint:errcheck
+		return nil, req.Context().Err()
+	}
+}
+
+// pipeResponseWriter turns what a handler writes into a response whose body streams the writes.
+type pipeResponseWriter struct {
+	req    *http.Request
+	header http.Header
+	body   *io.PipeReader
+	pw     *io.PipeWriter
+	once   sync.Once
+	ready  chan *http.Response
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(statusCode int) {
+	w.once.Do(func() {
+		w.ready <- &http.Response{
+			Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+			StatusCode:    statusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        w.header.Clone(),
+			Body:          w.body,
+			ContentLength: -1,
+			Request:       w.req,
+		}
+	})
+}
+
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(p)
+}
+
+// Flush is a no-op: writes reach the response body as soon as they are read.
+func (w *pipeResponseWriter) Flush() {}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// newProxyFunc returns the Proxy function of a transport reaching targets through the egress proxy,
+// except those of its no_proxy list
+func newProxyFunc(cfg *config.EgressProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	proxyURL, err := url.Parse(cfg.URL)
+	if err != nil || proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid egress proxy URL %q", cfg.URL)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported egress proxy scheme %q", proxyURL.Scheme)
+	}
+
+	if cfg.Username != "" {
+		proxyURL.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	noProxy := newNoProxy(cfg.NoProxy)
+
+	return func(req *http.Request) (*url.URL, error) {
+		if noProxy.match(req.URL) {
+			return nil, nil
+		}
+
+		return proxyURL, nil
+	}, nil
+}
+
+// noProxy matches the targets reached without proxy, as the NO_PROXY environment variable does
+type noProxy struct {
+	all     bool
+	cidrs   []*net.IPNet
+	entries []noProxyEntry
+}
+
+type noProxyEntry struct {
+	// host is an IP address or a domain name, matching its subdomains too unless subdomainsOnly
+	host           string
+	ip             net.IP
+	port           string
+	subdomainsOnly bool
+}
+
+func newNoProxy(patterns []string) *noProxy {
+	n := &noProxy{}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+
+		if pattern == "*" {
+			n.all = true
+			continue
+		}
+
+		if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			n.cidrs = append(n.cidrs, cidr)
+			continue
+		}
+
+		entry := noProxyEntry{host: pattern}
+		if host, port, err := net.SplitHostPort(pattern); err == nil {
+			entry.host, entry.port = host, port
+		}
+
+		if ip := net.ParseIP(entry.host); ip != nil {
+			entry.ip = ip
+		} else if host, ok := strings.CutPrefix(strings.TrimPrefix(entry.host, "*"), "."); ok {
+			entry.host, entry.subdomainsOnly = host, true
+		}
+
+		n.entries = append(n.entries, entry)
+	}
+
+	return n
+}
+
+// match reports whether the target is reached without proxy. CIDR ranges only match IP targets.
+func (n *noProxy) match(target *url.URL) bool {
+	if n.all {
+		return true
+	}
+
+	host, port := strings.ToLower(target.Hostname()), target.Port()
+	if port == "" {
+		port = "80"
+		if target.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	ip := net.ParseIP(host)
+	for _, cidr := range n.cidrs {
+		if ip != nil && cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	for _, entry := range n.entries {
+		if entry.port != "" && entry.port != port {
+			continue
+		}
+
+		switch {
+		case entry.ip != nil:
+			if ip != nil && entry.ip.Equal(ip) {
+				return true
+			}
+		case strings.HasSuffix(host, "."+entry.host):
+			return true
+		case host == entry.host && !entry.subdomainsOnly:
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package transport_test
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew_EgressProxy(t *testing.T) {
+	t.Parallel()
+
+	// newEgressProxy returns a forward proxy tunneling CONNECT requests and forwarding the others
+	newEgressProxy := func(t *testing.T, requests chan<- *http.Request) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests <- r
+
+			if r.Method != http.MethodConnect {
+				w.Write([]byte("forwarded to " + r.URL.Host)) //nolint:errcheck
+				return
+			}
+
+			upstream, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer upstream.Close() //nolint:errcheck
+
+			w.WriteHeader(http.StatusOK)
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close() //nolint:errcheck
+
+			go io.Copy(upstream, conn) //nolint:errcheck
+			io.Copy(conn, upstream)    //nolint:errcheck
+		}))
+		t.Cleanup(server.Close)
+
+		return server
+	}
+
+	t.Run("it should send http requests to the proxy with basic auth", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		proxy := newEgressProxy(t, requests)
+
+		target, _ := url.Parse("http://backend.example.com/users")
+		rt, err := transport.New(config.EndpointConfig{
+			EgressProxy: &config.EgressProxyConfig{URL: proxy.URL, Username: "heimdall", Password: "secret"},
+		}, target, "")
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, target.String(), nil)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "forwarded to backend.example.com", string(body))
+
+		received := <-requests
+		credentials := base64.StdEncoding.EncodeToString([]byte("heimdall:secret"))
+		require.Equal(t, "Basic "+credentials, received.Header.Get("Proxy-Authorization"))
+	})
+
+	t.Run("it should tunnel https requests with CONNECT", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		proxy := newEgressProxy(t, requests)
+
+		backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("through the tunnel")) //nolint:errcheck
+		}))
+		defer backend.Close()
+
+		target, _ := url.Parse(backend.URL)
+		rt, err := transport.New(config.EndpointConfig{
+			EgressProxy: &config.EgressProxyConfig{URL: proxy.URL},
+			TLS:         &config.TLSConfig{InsecureSkipVerify: true},
+		}, target, "")
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "through the tunnel", string(body))
+
+		received := <-requests
+		require.Equal(t, http.MethodConnect, received.Method)
+		require.Equal(t, target.Host, received.Host)
+	})
+
+	t.Run("it should reach the targets of the no_proxy list directly", func(t *testing.T) {
+		var proxied atomic.Int32
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied.Add(1)
+		}))
+		defer proxy.Close()
+
+		backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("direct")) //nolint:errcheck
+		}))
+		defer backend.Close()
+
+		target, _ := url.Parse(backend.URL)
+		rt, err := transport.New(config.EndpointConfig{
+			EgressProxy: &config.EgressProxyConfig{URL: proxy.URL, NoProxy: []string{"127.0.0.0/8"}},
+		}, target, "")
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest(http.MethodGet, backend.URL, nil)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "direct", string(body))
+		require.Zero(t, proxied.Load())
+	})
+
+	t.Run("it should match no_proxy patterns", func(t *testing.T) {
+		rt, err := transport.New(config.EndpointConfig{
+			EgressProxy: &config.EgressProxyConfig{
+				URL:     "http://proxy.corp:3128",
+				NoProxy: []string{"internal.corp", ".svc.cluster.local", "10.0.0.0/8", "192.168.1.10", "metrics.example.com:9090"},
+			},
+		}, nil, "")
+		require.NoError(t, err)
+
+		proxyFunc := rt.(*http.Transport).Proxy
+		for target, direct := range map[string]bool{
+			"http://internal.corp":                   true,
+			"https://api.internal.corp":              true,
+			"http://svc.cluster.local":               false,
+			"http://users.default.svc.cluster.local": true,
+			"http://10.1.2.3:8080":                   true,
+			"http://192.168.1.10":                    true,
+			"http://192.168.1.11":                    false,
+			"http://metrics.example.com:9090":        true,
+			"http://metrics.example.com":             false,
+			"https://api.example.com":                false,
+		} {
+			req, _ := http.NewRequest(http.MethodGet, target, nil)
+			proxyURL, err := proxyFunc(req)
+			require.NoError(t, err)
+			require.Equal(t, direct, proxyURL == nil, target)
+		}
+	})
+
+	t.Run("it should return an error for invalid egress proxies", func(t *testing.T) {
+		target, _ := url.Parse("http://backend")
+
+		_, err := transport.New(config.EndpointConfig{EgressProxy: &config.EgressProxyConfig{URL: "proxy.corp"}}, target, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{EgressProxy: &config.EgressProxyConfig{URL: "ftp://proxy.corp"}}, target, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{EgressProxy: &config.EgressProxyConfig{URL: "http://proxy.corp"}}, target, "/run/app.sock")
+		require.Error(t, err)
+	})
+}
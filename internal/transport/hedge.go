@@ -0,0 +1,325 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+const (
+	// defaultHedgeBudget is the ratio of requests hedged when the budget is not configured
+	defaultHedgeBudget = 0.1
+	// maxHedgeTokens bounds the hedges a burst of slow requests can spend after a quiet period
+	maxHedgeTokens = 10
+	// latencySamples is the number of recent latencies the percentile delay is computed from
+	latencySamples = 256
+	// minLatencySamples is the number of latencies observed before the percentile delay is used
+	minLatencySamples = 20
+)
+
+// Targets are the hosts a request can be sent to, such as an upstream pool
+type Targets interface {
+	Next() (*url.URL, bool)
+	Targets() []*url.URL
+	HostHeader(target *url.URL) string
+}
+
+// hedgingTransport sends a second request when the first one has not answered within the hedge
+// delay, and returns the response of whichever answers first
+type hedgingTransport struct {
+	next    http.RoundTripper
+	cfg     config.HedgeConfig
+	targets Targets
+
+	mutex     sync.Mutex
+	tokens    float64
+	latencies []time.Duration
+	position  int
+}
+
+// NewHedging returns a transport hedging the requests sent through next. Hedged requests are sent
+// to another target of targets if any, to the same target otherwise.
+func NewHedging(next http.RoundTripper, cfg config.HedgeConfig, targets Targets) http.RoundTripper {
+	if cfg.Budget == 0 {
+		cfg.Budget = defaultHedgeBudget
+	}
+
+	return &hedgingTransport{
+		next:      next,
+		cfg:       cfg,
+		targets:   targets,
+		latencies: make([]time.Duration, 0, latencySamples),
+	}
+}
+
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	hedged bool
+}
+
+// RoundTrip sends the request, hedging it if it is eligible and the budget allows it
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !hedgeable(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	t.deposit()
+
+	delay, ok := t.delay()
+	if !ok {
+		return t.observe(req)
+	}
+
+	// Each request is canceled on its own when the other one wins.
+	primaryCtx, cancelPrimary := context.WithCancel(req.Context())
+	hedgeCtx, cancelHedge := context.WithCancel(req.Context())
+
+	results := make(chan hedgeResult, 2)
+	start := time.Now()
+	t.send(req.WithContext(primaryCtx), false, results)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	var failed *hedgeResult
+	for {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				t.record(time.Since(start))
+				if result.hedged {
+					slog.DebugContext(req.Context(), "hedged request won", "url", result.resp.Request.URL.String())
+				}
+
+				// The losing request is canceled, its response discarded.
+				winner, loser := cancelPrimary, cancelHedge
+				if result.hedged {
+					winner, loser = cancelHedge, cancelPrimary
+				}
+
+				loser()
+				go discard(results, pending)
+
+				return t.win(result, winner), nil
+			}
+
+			if pending == 0 {
+				cancelPrimary()
+				cancelHedge()
+
+				if failed != nil {
+					return nil, failed.err
+				}
+
+				return nil, result.err
+			}
+
+			// The other request may still succeed.
+			failed = &result
+		case <-timer.C:
+			if !t.withdraw() {
+				continue
+			}
+
+			hedge, err := t.hedgeRequest(req.WithContext(hedgeCtx))
+			if err != nil {
+				slog.WarnContext(req.Context(), "could not hedge request", "url", req.URL.String(), "error", err)
+				continue
+			}
+
+			slog.DebugContext(req.Context(), "hedging request", "url", hedge.URL.String(), "delay", delay)
+			t.send(hedge, true, results)
+			pending++
+		}
+	}
+}
+
+// observe sends the request without hedging it, recording its latency
+func (t *hedgingTransport) observe(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err == nil {
+		t.record(time.Since(start))
+	}
+
+	return resp, err
+}
+
+// send starts a request, publishing its outcome to results
+func (t *hedgingTransport) send(req *http.Request, hedged bool, results chan<- hedgeResult) {
+	go func() {
+		resp, err := t.next.RoundTrip(req)
+		results <- hedgeResult{resp: resp, err: err, hedged: hedged}
+	}()
+}
+
+// win returns the response of the winning request, whose context is canceled once the body is closed
+func (t *hedgingTransport) win(result hedgeResult, cancel context.CancelFunc) *http.Response {
+	resp := result.resp
+	if resp.Body == nil {
+		cancel()
+		return resp
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp
+}
+
+// discard closes the responses of the canceled requests still pending once a response has won
+func discard(results <-chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		result := <-results
+		if result.err == nil {
+			result.resp.Body.Close() //nolint:errcheck
+		}
+	}
+}
+
+// hedgeRequest returns a copy of req sent to another target
+func (t *hedgingTransport) hedgeRequest(req *http.Request) (*http.Request, error) {
+	hedge := req.Clone(req.Context())
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+
+		hedge.Body = body
+	}
+
+	target := t.otherTarget(req.URL)
+	if target == nil {
+		return hedge, nil
+	}
+
+	hedge.URL.Host = target.Host
+	if target.Scheme != "" {
+		hedge.URL.Scheme = target.Scheme
+	}
+
+	// The Host header follows the target, unless it is preserved or overridden.
+	if req.Host == t.targets.HostHeader(req.URL) {
+		hedge.Host = t.targets.HostHeader(target)
+	}
+
+	return hedge, nil
+}
+
+// otherTarget returns a target different from the one of the first request, or nil if there is none
+func (t *hedgingTransport) otherTarget(primary *url.URL) *url.URL {
+	if t.targets == nil {
+		return nil
+	}
+
+	// Round robin pools return another target within as many calls as they have targets.
+	for range len(t.targets.Targets()) {
+		target, ok := t.targets.Next()
+		if !ok {
+			return nil
+		}
+
+		if target.Host != primary.Host {
+			return target
+		}
+	}
+
+	return nil
+}
+
+// delay returns the delay after which the request is hedged, or false if it is not known yet
+func (t *hedgingTransport) delay() (time.Duration, bool) {
+	if t.cfg.Percentile > 0 {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+
+		if len(t.latencies) >= minLatencySamples {
+			sorted := slices.Clone(t.latencies)
+			slices.Sort(sorted)
+
+			index := int(math.Ceil(t.cfg.Percentile/100*float64(len(sorted)))) - 1
+			return sorted[max(index, 0)], true
+		}
+	}
+
+	return t.cfg.Delay, t.cfg.Delay > 0
+}
+
+// record adds the latency of a successful request to the recent latencies
+func (t *hedgingTransport) record(latency time.Duration) {
+	if t.cfg.Percentile == 0 {
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.latencies) < latencySamples {
+		t.latencies = append(t.latencies, latency)
+		return
+	}
+
+	t.latencies[t.position] = latency
+	t.position = (t.position + 1) % latencySamples
+}
+
+// deposit credits the budget of a hedgeable request
+func (t *hedgingTransport) deposit() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.tokens = min(t.tokens+t.cfg.Budget, maxHedgeTokens)
+}
+
+// withdraw spends the budget of a hedge, returning false if it is exhausted
+func (t *hedgingTransport) withdraw() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.tokens < 1 {
+		return false
+	}
+
+	t.tokens--
+
+	return true
+}
+
+// hedgeable reports whether the request is idempotent and can be sent twice
+func hedgeable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	if req.Header.Get("Upgrade") != "" {
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// cancelBody cancels the context of its request once closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
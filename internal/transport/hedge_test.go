@@ -0,0 +1,177 @@
+package transport_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/stretchr/testify/require"
+)
+
+type staticTargets []*url.URL
+
+func (s staticTargets) Next() (*url.URL, bool) {
+	if len(s) == 0 {
+		return nil, false
+	}
+
+	return s[0], true
+}
+
+func (s staticTargets) Targets() []*url.URL {
+	return s
+}
+
+func (s staticTargets) HostHeader(target *url.URL) string {
+	return target.Host
+}
+
+func TestNewHedging(t *testing.T) {
+	t.Parallel()
+
+	// slowBackend answers after the request is canceled or a second, reporting the cancellation.
+	slowBackend := func(canceled chan<- struct{}) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+			case <-time.After(time.Second):
+				w.Write([]byte("slow")) //nolint:errcheck
+			}
+		}))
+	}
+
+	t.Run("it should use the response of the hedged request to another target", func(t *testing.T) {
+		t.Parallel()
+
+		canceled := make(chan struct{})
+		slow := slowBackend(canceled)
+		defer slow.Close()
+
+		fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("fast")) //nolint:errcheck
+		}))
+		defer fast.Close()
+
+		fastURL, _ := url.Parse(fast.URL)
+		rt := transport.NewHedging(http.DefaultTransport, config.HedgeConfig{Delay: 20 * time.Millisecond, Budget: 1}, staticTargets{fastURL})
+
+		req := httptest.NewRequest(http.MethodGet, slow.URL, nil)
+		req.RequestURI = ""
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "fast", string(body))
+
+		select {
+		case <-canceled:
+		case <-time.After(time.Second):
+			t.Fatal("the slow request was not canceled")
+		}
+	})
+
+	t.Run("it should not hedge requests answered within the delay", func(t *testing.T) {
+		t.Parallel()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("primary")) //nolint:errcheck
+		}))
+		defer primary.Close()
+
+		var hedged atomic.Int32
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hedged.Add(1)
+		}))
+		defer other.Close()
+
+		otherURL, _ := url.Parse(other.URL)
+		rt := transport.NewHedging(http.DefaultTransport, config.HedgeConfig{Delay: time.Second, Budget: 1}, staticTargets{otherURL})
+
+		req := httptest.NewRequest(http.MethodGet, primary.URL, nil)
+		req.RequestURI = ""
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "primary", string(body))
+		require.Zero(t, hedged.Load())
+	})
+
+	t.Run("it should not hedge non idempotent requests", func(t *testing.T) {
+		t.Parallel()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("primary")) //nolint:errcheck
+		}))
+		defer primary.Close()
+
+		var hedged atomic.Int32
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hedged.Add(1)
+		}))
+		defer other.Close()
+
+		otherURL, _ := url.Parse(other.URL)
+		rt := transport.NewHedging(http.DefaultTransport, config.HedgeConfig{Delay: time.Millisecond, Budget: 1}, staticTargets{otherURL})
+
+		req := httptest.NewRequest(http.MethodPost, primary.URL, strings.NewReader("payload"))
+		req.RequestURI = ""
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, _ := io.ReadAll(resp.Body)
+		require.Equal(t, "primary", string(body))
+		require.Zero(t, hedged.Load())
+	})
+
+	t.Run("it should stop hedging once the budget is spent", func(t *testing.T) {
+		t.Parallel()
+
+		primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(50 * time.Millisecond):
+				w.Write([]byte("primary")) //nolint:errcheck
+			}
+		}))
+		defer primary.Close()
+
+		var hedged atomic.Int32
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hedged.Add(1)
+			w.Write([]byte("other")) //nolint:errcheck
+		}))
+		defer other.Close()
+
+		otherURL, _ := url.Parse(other.URL)
+		rt := transport.NewHedging(http.DefaultTransport, config.HedgeConfig{Delay: 5 * time.Millisecond, Budget: 0.5}, staticTargets{otherURL})
+
+		var bodies []string
+		for range 4 {
+			req := httptest.NewRequest(http.MethodGet, primary.URL, nil)
+			req.RequestURI = ""
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close() //nolint:errcheck
+			bodies = append(bodies, string(body))
+		}
+
+		// Half a hedge is earned by each request.
+		require.Equal(t, []string{"primary", "other", "primary", "other"}, bodies)
+		require.Equal(t, int32(2), hedged.Load())
+	})
+}
@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxServerNames bounds the transports kept by serverNameTransport, the Host header being chosen by clients
+const maxServerNames = 64
+
+// serverNameTransport uses the Host header of each HTTPS request as TLS server name. Connections
+// are pooled by address, so each server name gets its own transport.
+type serverNameTransport struct {
+	base *http.Transport
+
+	mutex      sync.Mutex
+	transports map[string]*http.Transport
+}
+
+func newServerNameTransport(base *http.Transport) *serverNameTransport {
+	return &serverNameTransport{
+		base:       base,
+		transports: make(map[string]*http.Transport),
+	}
+}
+
+// RoundTrip sends the request with the transport of its server name
+func (t *serverNameTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	serverName := hostname(req.Host)
+	if req.URL.Scheme != "https" || serverName == "" || net.ParseIP(serverName) != nil {
+		return t.base.RoundTrip(req)
+	}
+
+	return t.transport(serverName).RoundTrip(req)
+}
+
+func (t *serverNameTransport) transport(serverName string) *http.Transport {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if transport, ok := t.transports[serverName]; ok {
+		return transport
+	}
+
+	if len(t.transports) >= maxServerNames {
+		for name, transport := range t.transports {
+			transport.CloseIdleConnections()
+			delete(t.transports, name)
+			break
+		}
+	}
+
+	transport := t.base.Clone()
+	transport.TLSClientConfig = withServerName(transport.TLSClientConfig, serverName)
+	t.transports[serverName] = transport
+
+	return transport
+}
+
+// CloseIdleConnections closes the idle connections of every transport
+func (t *serverNameTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, transport := range t.transports {
+		transport.CloseIdleConnections()
+	}
+}
+
+// withServerName returns a copy of tlsConfig with the given server name
+func withServerName(tlsConfig *tls.Config, serverName string) *tls.Config {
+	if tlsConfig == nil {
+		return &tls.Config{ServerName: serverName}
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = serverName
+
+	return tlsConfig
+}
+
+// hostname returns the host of a host:port Host header
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
@@ -0,0 +1,176 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig builds the TLS configuration used to reach an upstream. Certificate files
+// are reloaded when they change on disk.
+func newTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.MinVersion != "" {
+		version, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("invalid TLS min version %q", cfg.MinVersion)
+		}
+
+		tlsConfig.MinVersion = version
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("both cert_file and key_file must be set for client certificates")
+	}
+
+	files := &certFiles{caFile: cfg.CAFile, certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+	if err := files.load(); err != nil {
+		return nil, err
+	}
+
+	if cfg.CertFile != "" {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.clientCertificate(), nil
+		}
+	}
+
+	switch {
+	case cfg.InsecureSkipVerify:
+		slog.Warn("upstream TLS certificate verification is disabled", "server_name", cfg.ServerName)
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+	case cfg.CAFile != "":
+		// The default verification uses a fixed pool: verify against the current CA bundle instead.
+		tlsConfig.InsecureSkipVerify = true //nolint:gosec
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return files.verify(cs)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// certFiles holds the CA bundle and client certificate of an upstream, reloaded when the
+// files are modified.
+type certFiles struct {
+	caFile   string
+	certFile string
+	keyFile  string
+
+	mutex    sync.Mutex
+	modTimes map[string]time.Time
+	pool     *x509.CertPool
+	cert     *tls.Certificate
+}
+
+// load reads the files if they changed since the last load
+func (f *certFiles) load() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	modTimes := make(map[string]time.Time)
+	changed := f.modTimes == nil
+	for _, name := range []string{f.caFile, f.certFile, f.keyFile} {
+		if name == "" {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+
+		modTimes[name] = info.ModTime()
+		if !info.ModTime().Equal(f.modTimes[name]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	var pool *x509.CertPool
+	if f.caFile != "" {
+		pem, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", f.caFile)
+		}
+	}
+
+	var cert *tls.Certificate
+	if f.certFile != "" {
+		c, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return err
+		}
+
+		cert = &c
+	}
+
+	f.pool, f.cert, f.modTimes = pool, cert, modTimes
+	return nil
+}
+
+// reload reloads the files, keeping the previous ones when they can not be loaded
+func (f *certFiles) reload() {
+	if err := f.load(); err != nil {
+		slog.Error("failed to reload upstream TLS files, keeping the previous ones", "error", err)
+	}
+}
+
+func (f *certFiles) clientCertificate() *tls.Certificate {
+	f.reload()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.cert
+}
+
+// verify checks the peer certificate chain against the current CA bundle
+func (f *certFiles) verify(cs tls.ConnectionState) error {
+	f.reload()
+
+	f.mutex.Lock()
+	pool := f.pool
+	f.mutex.Unlock()
+
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("upstream presented no certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Roots:         pool,
+		Intermediates: intermediates,
+	})
+
+	return err
+}
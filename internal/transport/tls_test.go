@@ -0,0 +1,266 @@
+package transport_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/stretchr/testify/require"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCertificate issues a certificate signed by the parent, or self-signed when parent is nil
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate, isCA bool) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+func (c *testCertificate) writeFiles(t *testing.T, certFile, keyFile string) {
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0o600))
+
+	if keyFile != "" {
+		keyDER, err := x509.MarshalECPrivateKey(c.key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	}
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestNew_TLS(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCertificate(t, "Test CA", nil, true)
+	serverCert := newTestCertificate(t, "backend.internal", ca, false)
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.writeFiles(t, caFile, "")
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	t.Run("it should reach an mTLS upstream signed by a private CA", func(t *testing.T) {
+		certFile := filepath.Join(t.TempDir(), "client.pem")
+		keyFile := filepath.Join(t.TempDir(), "client-key.pem")
+		newTestCertificate(t, "heimdall", ca, false).writeFiles(t, certFile, keyFile)
+
+		rt, err := transport.New(config.EndpointConfig{TLS: &config.TLSConfig{
+			CAFile:     caFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "backend.internal",
+			MinVersion: "1.2",
+		}}, target, "")
+		require.NoError(t, err)
+
+		resp, err := (&http.Client{Transport: rt}).Get(backend.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, "heimdall", resp.Header.Get("X-Client"))
+	})
+
+	t.Run("it should reject upstreams signed by an unknown CA", func(t *testing.T) {
+		otherCAFile := filepath.Join(t.TempDir(), "other-ca.pem")
+		newTestCertificate(t, "Other CA", nil, true).writeFiles(t, otherCAFile, "")
+
+		rt, err := transport.New(config.EndpointConfig{TLS: &config.TLSConfig{
+			CAFile:     otherCAFile,
+			ServerName: "backend.internal",
+		}}, target, "")
+		require.NoError(t, err)
+
+		_, err = (&http.Client{Transport: rt}).Get(backend.URL) //nolint:bodyclose
+		require.Error(t, err)
+	})
+
+	t.Run("it should reload the client certificate when it changes on disk", func(t *testing.T) {
+		certFile := filepath.Join(t.TempDir(), "client.pem")
+		keyFile := filepath.Join(t.TempDir(), "client-key.pem")
+		newTestCertificate(t, "first", ca, false).writeFiles(t, certFile, keyFile)
+
+		rt, err := transport.New(config.EndpointConfig{TLS: &config.TLSConfig{
+			CAFile:     caFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "backend.internal",
+		}}, target, "")
+		require.NoError(t, err)
+
+		newTestCertificate(t, "second", ca, false).writeFiles(t, certFile, keyFile)
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(certFile, future, future))
+		require.NoError(t, os.Chtimes(keyFile, future, future))
+
+		resp, err := (&http.Client{Transport: rt}).Get(backend.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		require.Equal(t, "second", resp.Header.Get("X-Client"))
+	})
+
+	t.Run("it should return an error for invalid settings", func(t *testing.T) {
+		_, err := transport.New(config.EndpointConfig{TLS: &config.TLSConfig{MinVersion: "2.0"}}, target, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{TLS: &config.TLSConfig{CertFile: "client.pem"}}, target, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{TLS: &config.TLSConfig{CAFile: "missing.pem"}}, target, "")
+		require.Error(t, err)
+	})
+}
+
+func TestNew_ServerName(t *testing.T) {
+	t.Parallel()
+
+	ca := newTestCertificate(t, "Test CA", nil, true)
+	serverCert := newTestCertificate(t, "backend.internal", ca, false)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca.writeFiles(t, caFile, "")
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Server-Name", r.TLS.ServerName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	backend.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert.tlsCertificate()}}
+	backend.StartTLS()
+	defer backend.Close()
+
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	roundTrip := func(t *testing.T, rt http.RoundTripper, host string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+		req.Host = host
+
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close() //nolint:errcheck
+		}
+
+		return resp, err
+	}
+
+	t.Run("it should use the host override as server name", func(t *testing.T) {
+		rt, err := transport.New(config.EndpointConfig{
+			HostOverride: "backend.internal:443",
+			TLS:          &config.TLSConfig{CAFile: caFile},
+		}, target, "")
+		require.NoError(t, err)
+
+		resp, err := roundTrip(t, rt, "backend.internal:443")
+		require.NoError(t, err)
+		require.Equal(t, "backend.internal", resp.Header.Get("X-Server-Name"))
+	})
+
+	t.Run("it should use the preserved host of each request as server name", func(t *testing.T) {
+		rt, err := transport.New(config.EndpointConfig{
+			PreserveHost: true,
+			TLS:          &config.TLSConfig{CAFile: caFile},
+		}, target, "")
+		require.NoError(t, err)
+
+		resp, err := roundTrip(t, rt, "backend.internal")
+		require.NoError(t, err)
+		require.Equal(t, "backend.internal", resp.Header.Get("X-Server-Name"))
+
+		// The certificate of the upstream is verified against the preserved host.
+		_, err = roundTrip(t, rt, "other.internal")
+		require.Error(t, err)
+	})
+
+	t.Run("it should use the host of the endpoint target as server name of discovered targets", func(t *testing.T) {
+		rt, err := transport.New(config.EndpointConfig{
+			Upstream: &config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: config.DiscoveryDNS, Name: "backend.internal"}},
+			TLS:      &config.TLSConfig{CAFile: caFile},
+		}, target, "")
+		require.NoError(t, err)
+
+		resp, err := roundTrip(t, rt, "backend.internal:443")
+		require.NoError(t, err)
+		require.Equal(t, "backend.internal", resp.Header.Get("X-Server-Name"))
+	})
+
+	t.Run("it should prefer the configured server name", func(t *testing.T) {
+		rt, err := transport.New(config.EndpointConfig{
+			HostOverride: "api.example.com",
+			TLS:          &config.TLSConfig{CAFile: caFile, ServerName: "backend.internal"},
+		}, target, "")
+		require.NoError(t, err)
+
+		resp, err := roundTrip(t, rt, "api.example.com")
+		require.NoError(t, err)
+		require.Equal(t, "backend.internal", resp.Header.Get("X-Server-Name"))
+	})
+}
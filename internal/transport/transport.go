@@ -0,0 +1,88 @@
+// Package transport builds the HTTP transports used to reach upstream targets.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// New returns the transport used to reach the target of an endpoint. When socketPath is
+// set, connections are dialed to this Unix domain socket whatever the target host.
+// cgi:// and fastcgi:// targets are served by a CGI script or a FastCGI responder.
+// Requests go through the egress proxy of the endpoint, if any.
+// The TLS server name follows the Host header of preserve_host, host_override and discovery endpoints.
+func New(endpoint config.EndpointConfig, target *url.URL, socketPath string) (http.RoundTripper, error) {
+	if upstream, err := url.Parse(endpoint.Target); err == nil {
+		switch upstream.Scheme {
+		case "cgi":
+			return newCGITransport(upstream, endpoint.Path)
+		case "fastcgi":
+			return newFastCGITransport(upstream, endpoint.Path, endpoint.MaxBodyBytes)
+		case "handler":
+			// In-process handlers are served without a round trip.
+			return nil, nil
+		}
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if endpoint.EgressProxy != nil {
+		if socketPath != "" {
+			return nil, fmt.Errorf("egress proxy is not supported for unix targets")
+		}
+
+		proxy, err := newProxyFunc(endpoint.EgressProxy)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Proxy = proxy
+	}
+
+	if socketPath != "" {
+		dialer := &net.Dialer{Timeout: 30 * time.Second}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+	}
+
+	if config.IsGRPC(endpoint.Protocol) {
+		// gRPC requires HTTP/2 end to end: prior knowledge h2c for cleartext targets.
+		protocols := new(http.Protocols)
+		if target.Scheme == "https" {
+			protocols.SetHTTP2(true)
+		} else {
+			protocols.SetUnencryptedHTTP2(true)
+		}
+
+		t.Protocols = protocols
+	}
+
+	if endpoint.TLS != nil {
+		tlsConfig, err := newTLSConfig(endpoint.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		t.TLSClientConfig = tlsConfig
+	}
+
+	// The TLS server name follows the Host header sent upstream, unless it is configured.
+	if endpoint.TLS == nil || endpoint.TLS.ServerName == "" {
+		switch {
+		case endpoint.HostOverride != "":
+			t.TLSClientConfig = withServerName(t.TLSClientConfig, hostname(endpoint.HostOverride))
+		case endpoint.PreserveHost, endpoint.Upstream != nil && endpoint.Upstream.Discovery != nil:
+			// Discovered targets are sent the host of the endpoint target rather than their address.
+			return newServerNameTransport(t), nil
+		}
+	}
+
+	return t, nil
+}
@@ -0,0 +1,141 @@
+package transport_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/transport"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should build a default transport for http endpoints", func(t *testing.T) {
+		target, _ := url.Parse("http://backend")
+
+		rt, err := transport.New(config.EndpointConfig{}, target, "")
+		require.NoError(t, err)
+		require.IsType(t, &http.Transport{}, rt)
+		require.Nil(t, rt.(*http.Transport).Protocols)
+	})
+
+	t.Run("it should use h2c for cleartext gRPC targets", func(t *testing.T) {
+		target, _ := url.Parse("http://backend")
+
+		rt, err := transport.New(config.EndpointConfig{Protocol: config.ProtocolGRPC}, target, "")
+		require.NoError(t, err)
+
+		protocols := rt.(*http.Transport).Protocols
+		require.True(t, protocols.UnencryptedHTTP2())
+		require.False(t, protocols.HTTP1())
+	})
+
+	t.Run("it should use HTTP/2 for TLS gRPC targets", func(t *testing.T) {
+		target, _ := url.Parse("https://backend")
+
+		rt, err := transport.New(config.EndpointConfig{Protocol: config.ProtocolGRPC}, target, "")
+		require.NoError(t, err)
+
+		protocols := rt.(*http.Transport).Protocols
+		require.True(t, protocols.HTTP2())
+		require.False(t, protocols.UnencryptedHTTP2())
+	})
+
+	t.Run("it should dial unix domain sockets", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "heimdall")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir) //nolint:errcheck
+
+		socketPath := filepath.Join(dir, "app.sock")
+		listener, err := net.Listen("unix", socketPath)
+		require.NoError(t, err)
+
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path)) //nolint:errcheck
+		})}
+		go server.Serve(listener) //nolint:errcheck
+		defer server.Close()      //nolint:errcheck
+
+		target, _ := url.Parse("http://localhost/api")
+
+		rt, err := transport.New(config.EndpointConfig{}, target, socketPath)
+		require.NoError(t, err)
+
+		resp, err := (&http.Client{Transport: rt}).Get("http://localhost/api")
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "/api", string(body))
+	})
+
+	t.Run("it should run the script of cgi targets", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "heimdall")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir) //nolint:errcheck
+
+		script := filepath.Join(dir, "hello.cgi")
+		content := "#!/bin/sh\nprintf 'Status: 201 Created\\r\\nContent-Type: text/plain\\r\\n\\r\\n'\nprintf '%s %s %s' \"$REQUEST_METHOD\" \"$SCRIPT_NAME\" \"$QUERY_STRING\"\n"
+		require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+
+		rt, err := transport.New(config.EndpointConfig{Path: "/hello", Target: "cgi://" + script}, nil, "")
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodGet, "http://localhost/hello?name=heimdall", nil)
+		require.NoError(t, err)
+		req.RemoteAddr = "127.0.0.1:1234"
+
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		require.Equal(t, "GET /hello name=heimdall", string(body))
+	})
+
+	t.Run("it should send the host and the listener port of the request to cgi scripts", func(t *testing.T) {
+		script := filepath.Join(t.TempDir(), "server.cgi")
+		content := "#!/bin/sh\nprintf 'Content-Type: text/plain\\r\\n\\r\\n'\nprintf '%s %s' \"$SERVER_NAME\" \"$SERVER_PORT\"\n"
+		require.NoError(t, os.WriteFile(script, []byte(content), 0o755))
+
+		rt, err := transport.New(config.EndpointConfig{Path: "/server", Target: "cgi://" + script}, nil, "")
+		require.NoError(t, err)
+
+		ctx := context.WithValue(context.Background(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443})
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/server", nil)
+		require.NoError(t, err)
+		req.Host = "shop.example.com"
+		req.RemoteAddr = "127.0.0.1:1234"
+
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		defer resp.Body.Close() //nolint:errcheck
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, "shop.example.com 8443", string(body))
+	})
+
+	t.Run("it should return an error for invalid cgi and fastcgi targets", func(t *testing.T) {
+		_, err := transport.New(config.EndpointConfig{Target: "cgi://host/script"}, nil, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{Target: "fastcgi://127.0.0.1:9000"}, nil, "")
+		require.Error(t, err)
+
+		_, err = transport.New(config.EndpointConfig{Target: "fastcgi:///run/php-fpm.sock"}, nil, "")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,61 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// Discoverer finds the targets of an upstream pool, e.g. from DNS or a service registry.
+type Discoverer interface {
+	// Discover returns the current targets. Targets without scheme use the scheme of the endpoint target.
+	Discover(ctx context.Context) ([]*url.URL, error)
+}
+
+// DiscovererFunc adapts a function to the Discoverer interface.
+type DiscovererFunc func(ctx context.Context) ([]*url.URL, error)
+
+func (f DiscovererFunc) Discover(ctx context.Context) ([]*url.URL, error) {
+	return f(ctx)
+}
+
+// Factory builds the discoverer of a discovery configuration.
+type Factory func(cfg config.DiscoveryConfig) (Discoverer, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = map[string]Factory{
+		config.DiscoveryDNS:    newDNSDiscoverer,
+		config.DiscoveryDNSSRV: newSRVDiscoverer,
+		config.DiscoveryFile:   newFileDiscoverer,
+	}
+)
+
+// RegisterDiscovery registers a discovery type, referenced by the type of discovery configurations.
+func RegisterDiscovery(name string, factory Factory) error {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+
+	if _, exists := factories[name]; exists {
+		return fmt.Errorf("discovery with name '%s' already registered", name)
+	}
+
+	factories[name] = factory
+	return nil
+}
+
+// NewDiscoverer builds the discoverer of a discovery configuration.
+func NewDiscoverer(cfg config.DiscoveryConfig) (Discoverer, error) {
+	factoriesMutex.RLock()
+	factory, ok := factories[cfg.Type]
+	factoriesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown discovery type %q", cfg.Type)
+	}
+
+	return factory(cfg)
+}
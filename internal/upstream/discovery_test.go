@@ -0,0 +1,119 @@
+package upstream_test
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeResolver struct {
+	addrs   []net.IPAddr
+	records []*net.SRV
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return r.addrs, nil
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return name, r.records, nil
+}
+
+func TestDNSDiscoverer_Discover(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should resolve the A and AAAA records", func(t *testing.T) {
+		resolver := &fakeResolver{addrs: []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("fd00::1")}}}
+		discoverer := &upstream.DNSDiscoverer{Resolver: resolver, Name: "api.internal", Port: 8080}
+
+		targets, err := discoverer.Discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:8080"}, {Host: "[fd00::1]:8080"}}, targets)
+	})
+}
+
+func TestSRVDiscoverer_Discover(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should resolve the SRV records of the lowest priority", func(t *testing.T) {
+		resolver := &fakeResolver{records: []*net.SRV{
+			{Target: "api-1.internal.", Port: 8080, Priority: 10},
+			{Target: "api-2.internal.", Port: 8081, Priority: 10},
+			{Target: "backup.internal.", Port: 8080, Priority: 20},
+		}}
+		discoverer := &upstream.SRVDiscoverer{Resolver: resolver, Name: "_http._tcp.api.internal"}
+
+		targets, err := discoverer.Discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "api-1.internal:8080"}, {Host: "api-2.internal:8081"}}, targets)
+	})
+}
+
+func TestFileDiscoverer_Discover(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should read the targets and reload the file when it changes", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "targets.json")
+		require.NoError(t, os.WriteFile(path, []byte(`["10.0.0.1:8080", "https://10.0.0.2:8443"]`), 0o600))
+
+		discoverer, err := upstream.NewDiscoverer(config.DiscoveryConfig{Type: "file", File: path})
+		require.NoError(t, err)
+
+		targets, err := discoverer.Discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:8080"}, {Scheme: "https", Host: "10.0.0.2:8443"}}, targets)
+
+		require.NoError(t, os.WriteFile(path, []byte("targets:\n  - 10.0.0.3:8080\n"), 0o600))
+		later := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(path, later, later))
+
+		targets, err = discoverer.Discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "10.0.0.3:8080"}}, targets)
+	})
+
+	t.Run("it should return an error for invalid files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "targets.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("targets: [not-a-target]"), 0o600))
+
+		discoverer, err := upstream.NewDiscoverer(config.DiscoveryConfig{Type: "file", File: path})
+		require.NoError(t, err)
+
+		_, err = discoverer.Discover(context.Background())
+		require.Error(t, err)
+	})
+}
+
+func TestRegisterDiscovery(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should build registered discovery types", func(t *testing.T) {
+		err := upstream.RegisterDiscovery("static-registry", func(cfg config.DiscoveryConfig) (upstream.Discoverer, error) {
+			return upstream.DiscovererFunc(func(ctx context.Context) ([]*url.URL, error) {
+				return []*url.URL{{Host: cfg.Options["address"]}}, nil
+			}), nil
+		})
+		require.NoError(t, err)
+
+		discoverer, err := upstream.NewDiscoverer(config.DiscoveryConfig{Type: "static-registry", Options: map[string]string{"address": "10.0.0.1:80"}})
+		require.NoError(t, err)
+
+		targets, err := discoverer.Discover(context.Background())
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:80"}}, targets)
+	})
+
+	t.Run("it should prevent duplicate registration", func(t *testing.T) {
+		err := upstream.RegisterDiscovery("dns", nil)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "already registered")
+	})
+}
@@ -0,0 +1,82 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// Resolver is the subset of net.Resolver used by the DNS discoverers.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSDiscoverer resolves the A and AAAA records of a host name.
+type DNSDiscoverer struct {
+	Resolver Resolver
+	Name     string
+	Port     int
+}
+
+func newDNSDiscoverer(cfg config.DiscoveryConfig) (Discoverer, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("dns discovery requires a name")
+	}
+
+	return &DNSDiscoverer{Resolver: net.DefaultResolver, Name: cfg.Name, Port: cfg.Port}, nil
+}
+
+func (d *DNSDiscoverer) Discover(ctx context.Context) ([]*url.URL, error) {
+	addrs, err := d.Resolver.LookupIPAddr(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]*url.URL, 0, len(addrs))
+	for _, addr := range addrs {
+		targets = append(targets, &url.URL{Host: net.JoinHostPort(addr.String(), strconv.Itoa(d.Port))})
+	}
+
+	return targets, nil
+}
+
+// SRVDiscoverer resolves the SRV records of a name such as _http._tcp.api.internal.
+// Only the records of the lowest priority are used.
+type SRVDiscoverer struct {
+	Resolver Resolver
+	Name     string
+}
+
+func newSRVDiscoverer(cfg config.DiscoveryConfig) (Discoverer, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("dns-srv discovery requires a name")
+	}
+
+	return &SRVDiscoverer{Resolver: net.DefaultResolver, Name: cfg.Name}, nil
+}
+
+func (d *SRVDiscoverer) Discover(ctx context.Context) ([]*url.URL, error) {
+	_, records, err := d.Resolver.LookupSRV(ctx, "", "", d.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []*url.URL
+	for _, record := range records {
+		// Records are sorted by priority: lower priorities are backups.
+		if record.Priority != records[0].Priority {
+			break
+		}
+
+		host := strings.TrimSuffix(record.Target, ".")
+		targets = append(targets, &url.URL{Host: net.JoinHostPort(host, strconv.Itoa(int(record.Port)))})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// FileDiscoverer reads the targets listed in a JSON or YAML file, either as a list or
+// under a targets key. The file is parsed again only when its modification time changes.
+type FileDiscoverer struct {
+	Path string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	targets []*url.URL
+}
+
+func newFileDiscoverer(cfg config.DiscoveryConfig) (Discoverer, error) {
+	if cfg.File == "" {
+		return nil, fmt.Errorf("file discovery requires a file")
+	}
+
+	return &FileDiscoverer{Path: cfg.File}, nil
+}
+
+func (d *FileDiscoverer) Discover(_ context.Context) ([]*url.URL, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	info, err := os.Stat(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.targets != nil && info.ModTime().Equal(d.modTime) {
+		return d.targets, nil
+	}
+
+	data, err := os.ReadFile(d.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON being a subset of YAML, both are decoded by the YAML decoder.
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", d.Path, err)
+	}
+
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		var file struct {
+			Targets []string `yaml:"targets"`
+		}
+		if err := node.Decode(&file); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", d.Path, err)
+		}
+
+		list = file.Targets
+	}
+
+	targets := make([]*url.URL, 0, len(list))
+	for _, raw := range list {
+		u, err := ParseTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, u)
+	}
+
+	d.modTime = info.ModTime()
+	d.targets = targets
+
+	return targets, nil
+}
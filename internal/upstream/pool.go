@@ -0,0 +1,182 @@
+// Package upstream balances requests over pools of targets, kept up to date by discoverers.
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+)
+
+// Pool is a set of targets served in round-robin. Discovered targets are added to the static
+// targets of the pool and replaced on every refresh.
+type Pool struct {
+	static     []*url.URL
+	discoverer Discoverer
+	interval   time.Duration
+	// host is the Host header of the discovered targets, their own host if empty
+	host string
+
+	targets atomic.Pointer[[]*url.URL]
+	next    atomic.Uint64
+}
+
+// NewPool returns a pool of the static targets, refreshed every interval by the discoverer if any.
+func NewPool(static []*url.URL, discoverer Discoverer, interval time.Duration) *Pool {
+	p := &Pool{
+		static:     static,
+		discoverer: discoverer,
+		interval:   interval,
+	}
+	p.targets.Store(&static)
+
+	return p
+}
+
+// New returns the pool configured for an endpoint whose target is target.
+func New(cfg config.UpstreamConfig, target *url.URL) (*Pool, error) {
+	// A pool without targets would answer every request with a 503.
+	if len(cfg.Targets) == 0 && cfg.Discovery == nil {
+		return nil, fmt.Errorf("upstream requires targets or a discovery")
+	}
+
+	static := make([]*url.URL, 0, len(cfg.Targets))
+	for _, raw := range cfg.Targets {
+		u, err := ParseTarget(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		static = append(static, u)
+	}
+
+	if cfg.Discovery == nil {
+		return NewPool(static, nil, 0), nil
+	}
+
+	discovery := *cfg.Discovery
+	if discovery.Port == 0 {
+		discovery.Port = defaultPort(target)
+	}
+
+	discoverer, err := NewDiscoverer(discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := NewPool(static, discoverer, discovery.RefreshInterval)
+	pool.host = target.Host
+
+	return pool, nil
+}
+
+// Next returns the next target of the pool, or false if the pool is empty.
+// Targets without scheme use the scheme of the endpoint target.
+func (p *Pool) Next() (*url.URL, bool) {
+	targets := *p.targets.Load()
+	if len(targets) == 0 {
+		return nil, false
+	}
+
+	return targets[(p.next.Add(1)-1)%uint64(len(targets))], true
+}
+
+// HostHeader returns the Host header of the requests sent to target. Discovered targets, often
+// IP addresses, are sent the host of the endpoint target, and static ones their own host.
+func (p *Pool) HostHeader(target *url.URL) string {
+	if p.host == "" || slices.ContainsFunc(p.static, func(u *url.URL) bool { return u.Host == target.Host }) {
+		return target.Host
+	}
+
+	return p.host
+}
+
+// Targets returns the current targets of the pool.
+func (p *Pool) Targets() []*url.URL {
+	return slices.Clone(*p.targets.Load())
+}
+
+// Refresh discovers the targets of the pool. The previous targets are kept when the
+// discovery fails or finds nothing, so that a resolution hiccup does not empty the pool.
+func (p *Pool) Refresh(ctx context.Context) error {
+	if p.discoverer == nil {
+		return nil
+	}
+
+	discovered, err := p.discoverer.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(discovered) == 0 {
+		return fmt.Errorf("discovery returned no targets")
+	}
+
+	targets := append(slices.Clone(p.static), discovered...)
+	p.targets.Store(&targets)
+
+	return nil
+}
+
+// Run refreshes the pool every interval until ctx is done.
+func (p *Pool) Run(ctx context.Context) {
+	if p.discoverer == nil || p.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Refresh(ctx); err != nil && ctx.Err() == nil {
+				slog.WarnContext(ctx, "failed to refresh upstream targets", "error", err)
+			}
+		}
+	}
+}
+
+// ParseTarget parses a target written either as a URL or as host:port.
+func ParseTarget(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		if _, _, err := net.SplitHostPort(raw); err != nil {
+			return nil, fmt.Errorf("invalid upstream target %q: %w", raw, err)
+		}
+
+		return &url.URL{Host: raw}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid upstream target %q: missing host", raw)
+	}
+
+	return &url.URL{Scheme: u.Scheme, Host: u.Host}, nil
+}
+
+func defaultPort(target *url.URL) int {
+	if port := target.Port(); port != "" {
+		n, _ := net.LookupPort("tcp", port)
+		return n
+	}
+
+	if target.Scheme == "https" {
+		return 443
+	}
+
+	return 80
+}
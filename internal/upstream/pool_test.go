@@ -0,0 +1,159 @@
+package upstream_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/upstream"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+
+	u, err := upstream.ParseTarget(raw)
+	require.NoError(t, err)
+
+	return u
+}
+
+func TestPool_Next(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should serve the targets in round-robin", func(t *testing.T) {
+		pool := upstream.NewPool([]*url.URL{mustParse(t, "10.0.0.1:80"), mustParse(t, "10.0.0.2:80")}, nil, 0)
+
+		var hosts []string
+		for range 4 {
+			target, ok := pool.Next()
+			require.True(t, ok)
+			hosts = append(hosts, target.Host)
+		}
+
+		require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.1:80", "10.0.0.2:80"}, hosts)
+	})
+
+	t.Run("it should return false if the pool is empty", func(t *testing.T) {
+		pool := upstream.NewPool(nil, nil, 0)
+
+		_, ok := pool.Next()
+		require.False(t, ok)
+	})
+}
+
+func TestPool_Refresh(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should add the discovered targets to the static targets", func(t *testing.T) {
+		discoverer := upstream.DiscovererFunc(func(ctx context.Context) ([]*url.URL, error) {
+			return []*url.URL{mustParse(t, "http://10.0.0.2:8080")}, nil
+		})
+		pool := upstream.NewPool([]*url.URL{mustParse(t, "10.0.0.1:8080")}, discoverer, time.Second)
+
+		require.NoError(t, pool.Refresh(context.Background()))
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:8080"}, {Scheme: "http", Host: "10.0.0.2:8080"}}, pool.Targets())
+	})
+
+	t.Run("it should keep the previous targets if the discovery fails or finds nothing", func(t *testing.T) {
+		results := [][]*url.URL{{mustParse(t, "10.0.0.1:80")}, nil, {}}
+		errs := []error{nil, errors.New("lookup failed"), nil}
+
+		call := 0
+		discoverer := upstream.DiscovererFunc(func(ctx context.Context) ([]*url.URL, error) {
+			defer func() { call++ }()
+			return results[call], errs[call]
+		})
+		pool := upstream.NewPool(nil, discoverer, time.Second)
+
+		require.NoError(t, pool.Refresh(context.Background()))
+		require.Error(t, pool.Refresh(context.Background()))
+		require.Error(t, pool.Refresh(context.Background()))
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:80"}}, pool.Targets())
+	})
+}
+
+func TestPool_Run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("it should refresh the targets periodically", func(t *testing.T) {
+		hosts := make(chan string, 1)
+		hosts <- "10.0.0.1:80"
+
+		discoverer := upstream.DiscovererFunc(func(ctx context.Context) ([]*url.URL, error) {
+			select {
+			case host := <-hosts:
+				return []*url.URL{{Host: host}}, nil
+			default:
+				return nil, errors.New("no change")
+			}
+		})
+		pool := upstream.NewPool(nil, discoverer, 10*time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go pool.Run(ctx)
+
+		require.Eventually(t, func() bool { return len(pool.Targets()) == 1 }, time.Second, 5*time.Millisecond)
+
+		hosts <- "10.0.0.2:80"
+		require.Eventually(t, func() bool { return pool.Targets()[0].Host == "10.0.0.2:80" }, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	target, _ := url.Parse("https://api.internal/v1")
+
+	t.Run("it should build a pool of the static targets", func(t *testing.T) {
+		pool, err := upstream.New(config.UpstreamConfig{Targets: []string{"10.0.0.1:8443", "https://10.0.0.2"}}, target)
+		require.NoError(t, err)
+		require.Equal(t, []*url.URL{{Host: "10.0.0.1:8443"}, {Scheme: "https", Host: "10.0.0.2"}}, pool.Targets())
+	})
+
+	t.Run("it should return an error for upstreams without targets nor discovery", func(t *testing.T) {
+		_, err := upstream.New(config.UpstreamConfig{EgressProxy: &config.EgressProxyConfig{URL: "http://proxy.corp:3128"}}, target)
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error for invalid targets", func(t *testing.T) {
+		_, err := upstream.New(config.UpstreamConfig{Targets: []string{"10.0.0.1"}}, target)
+		require.Error(t, err)
+	})
+
+	t.Run("it should return an error for unknown discovery types", func(t *testing.T) {
+		_, err := upstream.New(config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: "unknown"}}, target)
+		require.Error(t, err)
+	})
+
+	t.Run("it should default the dns port to the port of the target", func(t *testing.T) {
+		pool, err := upstream.New(config.UpstreamConfig{Discovery: &config.DiscoveryConfig{Type: "dns", Name: "localhost"}}, target)
+		require.NoError(t, err)
+
+		require.NoError(t, pool.Refresh(context.Background()))
+		for _, u := range pool.Targets() {
+			require.Contains(t, u.Host, ":443")
+		}
+	})
+	t.Run("it should send the host of the target to the discovered targets", func(t *testing.T) {
+		pool, err := upstream.New(config.UpstreamConfig{
+			Targets:   []string{"10.0.0.1:8443"},
+			Discovery: &config.DiscoveryConfig{Type: "dns", Name: "localhost"},
+		}, target)
+		require.NoError(t, err)
+
+		require.Equal(t, "10.0.0.1:8443", pool.HostHeader(&url.URL{Host: "10.0.0.1:8443"}))
+		require.Equal(t, "api.internal", pool.HostHeader(&url.URL{Host: "127.0.0.1:443"}))
+	})
+
+	t.Run("it should send their own host to the targets of pools without discovery", func(t *testing.T) {
+		pool, err := upstream.New(config.UpstreamConfig{Targets: []string{"10.0.0.1:8443"}}, target)
+		require.NoError(t, err)
+
+		require.Equal(t, "10.0.0.1:8443", pool.HostHeader(&url.URL{Host: "10.0.0.1:8443"}))
+	})
+}
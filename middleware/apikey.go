@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/arthurdotwork/heimdall"
+	"github.com/arthurdotwork/heimdall/apikey"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+)
+
+// APIKeyConfig holds configuration for the API key middleware
+type APIKeyConfig struct {
+	// Store validates the keys and returns their consumer, it is required: without it, every key is rejected
+	Store apikey.Store
+	// Header carries the key, X-API-Key by default
+	Header string
+	// QueryParam carries the key when the header is absent, disabled by default
+	QueryParam string
+	// ForwardHeader is the request header carrying the consumer ID upstream, none by default
+	ForwardHeader string
+	// ForwardMetadata maps consumer metadata to the request headers carrying them upstream
+	ForwardMetadata map[string]string
+}
+
+type consumerContextKey struct{}
+
+// APIKeyConsumer returns the consumer authenticated by the API key middleware
+func APIKeyConsumer(ctx context.Context) (*apikey.Consumer, bool) {
+	consumer, ok := ctx.Value(consumerContextKey{}).(*apikey.Consumer)
+	return consumer, ok
+}
+
+// APIKey creates a middleware authenticating requests with an API key. The key is removed from
+// the request before it is forwarded, and unknown keys are rejected with a 401.
+// A store is required: without it, as with a nil config, every key is rejected.
+func APIKey(config *APIKeyConfig) heimdall.Middleware {
+	if config == nil {
+		config = &APIKeyConfig{}
+	}
+
+	store := config.Store
+	if store == nil {
+		slog.Warn("API key middleware has no store, every key will be rejected")
+		store = apikey.NewMemoryStore()
+	}
+
+	header := config.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+
+	challenge := fmt.Sprintf("APIKey header=%q", header)
+
+	return heimdall.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(header)
+
+			rawQuery, found := r.URL.RawQuery, false
+			if config.QueryParam != "" {
+				var queryKey string
+				rawQuery, queryKey, found = removeQueryParam(r.URL.RawQuery, config.QueryParam)
+				if key == "" {
+					key = queryKey
+				}
+			}
+
+			if key == "" {
+				writeAuthError(w, r, http.StatusUnauthorized, challenge, "Missing API key")
+				return
+			}
+
+			consumer, ok := store.Lookup(r.Context(), key)
+			if !ok {
+				writeAuthError(w, r, http.StatusUnauthorized, challenge, "Invalid API key")
+				return
+			}
+
+			// Responses to a consumer are not shared by a cache running before the authentication.
+			markPrivate(r.Context())
+
+			ctx := context.WithValue(r.Context(), consumerContextKey{}, consumer)
+			if forwarded := forwardedConsumer(consumer, config); len(forwarded) > 0 {
+				ctx = headers.WithUpstream(ctx, forwarded)
+			}
+
+			// The request of the caller is left untouched, the key being removed from a copy.
+			outreq := r.Clone(ctx)
+			outreq.Header.Del(header)
+			if found {
+				outreq.URL.RawQuery = rawQuery
+			}
+
+			next.ServeHTTP(w, outreq)
+		})
+	})
+}
+
+// removeQueryParam removes the parameter from a raw query, keeping the order and encoding of
+// the others. It returns the first value of the parameter and whether it was present.
+func removeQueryParam(rawQuery, name string) (string, string, bool) {
+	var kept []string
+	var value string
+	found := false
+
+	for _, param := range strings.Split(rawQuery, "&") {
+		rawKey, rawValue, _ := strings.Cut(param, "=")
+		if key, err := url.QueryUnescape(rawKey); err != nil || key != name {
+			kept = append(kept, param)
+			continue
+		}
+
+		if !found {
+			value, _ = url.QueryUnescape(rawValue)
+			found = true
+		}
+	}
+
+	return strings.Join(kept, "&"), value, found
+}
+
+// forwardedConsumer returns the headers carrying the consumer upstream. Headers of absent
+// metadata are sent empty, so that the client values are removed.
+func forwardedConsumer(consumer *apikey.Consumer, config *APIKeyConfig) http.Header {
+	header := make(http.Header, len(config.ForwardMetadata)+1)
+	if config.ForwardHeader != "" {
+		header.Set(config.ForwardHeader, consumer.ID)
+	}
+
+	for name, headerName := range config.ForwardMetadata {
+		value, ok := consumer.Metadata[name]
+		if !ok {
+			header[http.CanonicalHeaderKey(headerName)] = nil
+			continue
+		}
+
+		header.Set(headerName, value)
+	}
+
+	return header
+}
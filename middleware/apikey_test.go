@@ -0,0 +1,148 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arthurdotwork/heimdall/apikey"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	internalMiddleware "github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyMiddleware(t *testing.T) {
+	t.Parallel()
+
+	store := apikey.NewMemoryStore()
+	store.Add("key-a", apikey.Consumer{ID: "partner-a", Metadata: map[string]string{"plan": "gold"}})
+
+	// serve sends the request to the middleware, recording the request reaching the next handler
+	serve := func(config *middleware.APIKeyConfig, req *http.Request) (*httptest.ResponseRecorder, *http.Request) {
+		var forwarded *http.Request
+		handler := internalMiddleware.NewChain().Add(middleware.APIKey(config)).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = r
+			consumer, ok := middleware.APIKeyConsumer(r.Context())
+			if ok {
+				w.Write([]byte(consumer.ID)) //nolint:errcheck
+			}
+		}))
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		return recorder, forwarded
+	}
+
+	t.Run("it should authenticate the key of the header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", "key-a")
+
+		recorder, forwarded := serve(&middleware.APIKeyConfig{Store: store}, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "partner-a", recorder.Body.String())
+		require.Empty(t, forwarded.Header.Get("X-API-Key"))
+
+		// The key is removed from a copy of the request.
+		require.Equal(t, "key-a", req.Header.Get("X-API-Key"))
+	})
+
+	t.Run("it should authenticate the key of the query parameter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/?z=1&api_key=key-a&name=a%20b&tag=x+y&a=2", nil)
+
+		recorder, forwarded := serve(&middleware.APIKeyConfig{Store: store, QueryParam: "api_key"}, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "z=1&name=a%20b&tag=x+y&a=2", forwarded.URL.RawQuery)
+		require.Equal(t, "z=1&api_key=key-a&name=a%20b&tag=x+y&a=2", req.URL.RawQuery)
+	})
+
+	t.Run("it should reject missing and unknown keys", func(t *testing.T) {
+		config := &middleware.APIKeyConfig{Store: store, Header: "Api-Key"}
+
+		recorder, _ := serve(config, httptest.NewRequest(http.MethodGet, "/?api_key=key-a", nil))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Equal(t, `APIKey header="Api-Key"`, recorder.Header().Get("WWW-Authenticate"))
+		require.Contains(t, recorder.Body.String(), "Missing API key")
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Api-Key", "key-b")
+		recorder, _ = serve(config, req)
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Contains(t, recorder.Body.String(), "Invalid API key")
+	})
+
+	t.Run("it should reject every key without a store", func(t *testing.T) {
+		for _, config := range []*middleware.APIKeyConfig{nil, {}} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-API-Key", "key-a")
+
+			recorder, forwarded := serve(config, req)
+			require.Equal(t, http.StatusUnauthorized, recorder.Code)
+			require.Contains(t, recorder.Body.String(), "Invalid API key")
+			require.Nil(t, forwarded)
+		}
+	})
+
+	t.Run("it should forward the consumer upstream", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", "key-a")
+
+		recorder, forwarded := serve(&middleware.APIKeyConfig{
+			Store:           store,
+			ForwardHeader:   "X-Consumer-Id",
+			ForwardMetadata: map[string]string{"plan": "X-Consumer-Plan", "region": "X-Consumer-Region"},
+		}, req)
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		upstream := headers.UpstreamFromContext(forwarded.Context())
+		require.Equal(t, "partner-a", upstream.Get("X-Consumer-Id"))
+		require.Equal(t, "gold", upstream.Get("X-Consumer-Plan"))
+
+		values, ok := upstream["X-Consumer-Region"]
+		require.True(t, ok)
+		require.Empty(t, values)
+	})
+
+	t.Run("it should not share cached responses between consumers", func(t *testing.T) {
+		store := apikey.NewMemoryStore()
+		store.Add("key-a", apikey.Consumer{ID: "partner-a"})
+		store.Add("key-b", apikey.Consumer{ID: "partner-b"})
+
+		upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Write([]byte(headers.UpstreamFromContext(r.Context()).Get("X-Consumer-Id"))) //nolint:errcheck
+		})
+
+		get := func(handler http.Handler, key string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if key != "" {
+				req.Header.Set("X-API-Key", key)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			return recorder
+		}
+
+		for name, chain := range map[string]*internalMiddleware.Chain{
+			"cache first": internalMiddleware.NewChain().
+				Add(middleware.Cache(nil)).
+				Add(middleware.APIKey(&middleware.APIKeyConfig{Store: store, ForwardHeader: "X-Consumer-Id"})),
+			"api key first": internalMiddleware.NewChain().
+				Add(middleware.APIKey(&middleware.APIKeyConfig{Store: store, ForwardHeader: "X-Consumer-Id"})).
+				Add(middleware.Cache(nil)),
+		} {
+			handler := chain.Then(upstream)
+
+			for _, key := range []string{"key-a", "key-a"} {
+				recorder := get(handler, key)
+				require.Equal(t, http.StatusOK, recorder.Code, name)
+				require.Equal(t, "partner-a", recorder.Body.String(), name)
+			}
+
+			require.Equal(t, "partner-b", get(handler, "key-b").Body.String(), name)
+			require.Equal(t, http.StatusUnauthorized, get(handler, "").Code, name)
+		}
+	})
+}
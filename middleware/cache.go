@@ -0,0 +1,639 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arthurdotwork/heimdall"
+	"github.com/arthurdotwork/heimdall/cache"
+	"github.com/arthurdotwork/heimdall/internal/config"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// CacheHeader reports how the cache served a response: HIT, MISS, STALE or BYPASS
+const CacheHeader = "X-Cache"
+
+// CacheConfig holds configuration for the cache middleware
+type CacheConfig struct {
+	// Store holds the cached responses, an in-memory LRU store of 1000 entries by default
+	Store cache.Store
+	// DefaultTTL is the freshness lifetime of responses without Cache-Control max-age or Expires,
+	// 0 only caches them when they can be revalidated
+	DefaultTTL time.Duration
+	// MaxBodyBytes is the maximum size of the cached responses, 0 means no limit
+	MaxBodyBytes int
+	// KeyFunc returns the cache key of a request, its host and URL by default
+	KeyFunc func(r *http.Request) string
+}
+
+// DefaultCacheConfig returns a default cache configuration
+func DefaultCacheConfig() *CacheConfig {
+	return &CacheConfig{
+		Store:        cache.NewMemoryStore(1000),
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// cacheableStatuses are the statuses cacheable by default (RFC 9110, section 15.1)
+var cacheableStatuses = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// Cache creates a middleware caching GET responses as a shared cache, honoring Cache-Control,
+// Expires, Vary, revalidation with ETag and Last-Modified, stale-while-revalidate and stale-if-error.
+// The cache settings of the endpoint override the freshness lifetime and extend the cache key.
+func Cache(config *CacheConfig) heimdall.Middleware {
+	if config == nil {
+		config = DefaultCacheConfig()
+	}
+
+	c := &cacheHandler{
+		store:        config.Store,
+		defaultTTL:   config.DefaultTTL,
+		maxBodyBytes: config.MaxBodyBytes,
+		keyFunc:      config.KeyFunc,
+	}
+	if c.store == nil {
+		c.store = cache.NewMemoryStore(1000)
+	}
+
+	if c.keyFunc == nil {
+		c.keyFunc = defaultCacheKey
+	}
+
+	return heimdall.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c.serve(w, r, next)
+		})
+	})
+}
+
+func defaultCacheKey(r *http.Request) string {
+	return r.Host + r.URL.RequestURI()
+}
+
+type cacheRequestKey struct{}
+
+// cacheRequest lets the middlewares following the cache keep a response out of it, such as the
+// ones authenticating clients with credentials the cache does not recognize
+type cacheRequest struct {
+	private bool
+}
+
+// markPrivate prevents the cache middleware serving the request from storing its response
+func markPrivate(ctx context.Context) {
+	if cr, ok := ctx.Value(cacheRequestKey{}).(*cacheRequest); ok {
+		cr.private = true
+	}
+}
+
+type cacheHandler struct {
+	store        cache.Store
+	defaultTTL   time.Duration
+	maxBodyBytes int
+	keyFunc      func(r *http.Request) string
+
+	// revalidating holds the keys being revalidated in the background
+	revalidating sync.Map
+}
+
+func (c *cacheHandler) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	var endpoint *config.CacheConfig
+	if route, ok := router.RouteFromContext(r.Context()); ok {
+		endpoint = route.Cache
+	}
+
+	if endpoint != nil && endpoint.Disabled {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	key := c.key(r, endpoint)
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodHead, http.MethodOptions, http.MethodTrace:
+		next.ServeHTTP(w, r)
+		return
+	default:
+		// Unsafe requests invalidate the cached responses of their URL.
+		next.ServeHTTP(w, r)
+		c.store.Delete(r.Context(), key)
+		return
+	}
+
+	directives := parseCacheControl(r.Header.Values("Cache-Control"))
+	if _, noStore := directives["no-store"]; noStore || r.Header.Get("Authorization") != "" || r.Header.Get("Upgrade") != "" {
+		w.Header().Set(CacheHeader, "BYPASS")
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	now := time.Now()
+	entry := c.lookup(r.Context(), key, r)
+	if entry != nil && entry.Expired(now) {
+		entry = nil
+	}
+
+	if entry != nil && acceptsCached(directives, entry, now) {
+		if entry.Fresh(now) {
+			serveEntry(w, r, entry, now, "HIT")
+			return
+		}
+
+		if entry.UsableWhileRevalidating(now) {
+			serveEntry(w, r, entry, now, "STALE")
+			c.revalidateInBackground(r, key, entry, endpoint, next)
+			return
+		}
+	}
+
+	c.fetch(w, r, next, key, entry, endpoint, now)
+}
+
+// acceptsCached reports whether the request allows answering with the entry without contacting the upstream
+func acceptsCached(directives map[string]string, entry *cache.Entry, now time.Time) bool {
+	if _, noCache := directives["no-cache"]; noCache {
+		return false
+	}
+
+	if maxAge, ok := directiveSeconds(directives, "max-age"); ok && entry.Age(now) > maxAge {
+		return false
+	}
+
+	return true
+}
+
+// fetch forwards the request, revalidating the stale entry if any, and stores the response
+func (c *cacheHandler) fetch(w http.ResponseWriter, r *http.Request, next http.Handler, key string, entry *cache.Entry, endpoint *config.CacheConfig, now time.Time) {
+	cr := &cacheRequest{}
+	outreq := r.WithContext(context.WithValue(r.Context(), cacheRequestKey{}, cr))
+	revalidate := entry != nil && hasValidators(entry.Header) && !hasConditionals(r)
+	if revalidate {
+		outreq = withConditionals(outreq, entry)
+	}
+
+	cw := &cacheWriter{
+		ResponseWriter: w,
+		before:         w.Header().Clone(),
+		maxBodyBytes:   c.maxBodyBytes,
+		intercept: func(status int) bool {
+			if entry == nil {
+				return false
+			}
+
+			return revalidate && status == http.StatusNotModified || status >= http.StatusInternalServerError && entry.UsableOnError(now)
+		},
+	}
+	w.Header().Set(CacheHeader, "MISS")
+
+	next.ServeHTTP(cw, outreq)
+
+	switch {
+	case cw.intercepted && cw.status == http.StatusNotModified:
+		entry = c.refresh(r.Context(), key, r, entry, cw.header, endpoint)
+		serveEntry(w, r, entry, time.Now(), "HIT")
+	case cw.intercepted:
+		serveEntry(w, r, entry, time.Now(), "STALE")
+	case cw.wroteHeader && !cw.overflow && !cr.private:
+		if stored, ok := c.newEntry(cw.status, cw.header, cw.body, endpoint); ok {
+			c.save(r.Context(), key, r, stored)
+		}
+	}
+}
+
+// revalidateInBackground refreshes the entry once the stale response has been served
+func (c *cacheHandler) revalidateInBackground(r *http.Request, key string, entry *cache.Entry, endpoint *config.CacheConfig, next http.Handler) {
+	if _, loaded := c.revalidating.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+
+	cr := &cacheRequest{}
+	outreq := r.Clone(context.WithValue(context.WithoutCancel(r.Context()), cacheRequestKey{}, cr))
+	outreq.Body, outreq.ContentLength = http.NoBody, 0
+	for _, name := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		outreq.Header.Del(name)
+	}
+
+	if hasValidators(entry.Header) {
+		outreq = withConditionals(outreq, entry)
+	}
+
+	go func() {
+		defer c.revalidating.Delete(key)
+
+		rw := &bufferedResponse{header: make(http.Header)}
+		next.ServeHTTP(rw, outreq)
+
+		switch {
+		case rw.status == http.StatusNotModified:
+			c.refresh(outreq.Context(), key, outreq, entry, rw.header, endpoint)
+		case rw.status >= http.StatusInternalServerError:
+			// The stale entry stays usable until the upstream recovers.
+		case cr.private:
+			c.store.Delete(outreq.Context(), key)
+		default:
+			if stored, ok := c.newEntry(rw.status, rw.header, rw.body.Bytes(), endpoint); ok {
+				c.save(outreq.Context(), key, outreq, stored)
+			} else {
+				c.store.Delete(outreq.Context(), key)
+			}
+		}
+	}()
+}
+
+// refresh updates the entry with the headers of a 304 Not Modified response
+func (c *cacheHandler) refresh(ctx context.Context, key string, r *http.Request, entry *cache.Entry, header http.Header, endpoint *config.CacheConfig) *cache.Entry {
+	merged := entry.Header.Clone()
+	for name, values := range header {
+		if name != "Content-Length" {
+			merged[name] = values
+		}
+	}
+
+	updated, ok := c.newEntry(entry.Status, merged, entry.Body, endpoint)
+	if !ok {
+		c.store.Delete(ctx, key)
+		return &cache.Entry{Status: entry.Status, Header: merged, Body: entry.Body, StoredAt: time.Now()}
+	}
+
+	c.save(ctx, key, r, updated)
+	return updated
+}
+
+// key returns the cache key of the request. The headers sent upstream on behalf of the middlewares
+// preceding the cache, such as the authenticated consumer, are part of the key.
+func (c *cacheHandler) key(r *http.Request, endpoint *config.CacheConfig) string {
+	key := c.keyFunc(r)
+	if endpoint != nil {
+		for _, name := range endpoint.KeyHeaders {
+			key += "\x00" + http.CanonicalHeaderKey(name) + "=" + strings.Join(r.Header.Values(name), ",")
+		}
+	}
+
+	upstream := headers.UpstreamFromContext(r.Context())
+	for _, name := range slices.Sorted(maps.Keys(upstream)) {
+		key += "\x01" + name + "=" + strings.Join(upstream[name], ",")
+	}
+
+	return key
+}
+
+// lookup returns the entry of the request, following the index of varying responses
+func (c *cacheHandler) lookup(ctx context.Context, key string, r *http.Request) *cache.Entry {
+	entry, ok := c.store.Get(ctx, key)
+	if !ok {
+		return nil
+	}
+
+	if entry.Status == 0 && len(entry.Vary) > 0 {
+		if entry, ok = c.store.Get(ctx, variantKey(key, r, entry.Vary)); !ok {
+			return nil
+		}
+	}
+
+	return entry
+}
+
+// save stores the entry, under the key of its variant if the response varies
+func (c *cacheHandler) save(ctx context.Context, key string, r *http.Request, entry *cache.Entry) {
+	if len(entry.Vary) == 0 {
+		c.store.Set(ctx, key, entry)
+		return
+	}
+
+	c.store.Set(ctx, key, &cache.Entry{Vary: entry.Vary})
+	c.store.Set(ctx, variantKey(key, r, entry.Vary), entry)
+}
+
+func variantKey(key string, r *http.Request, vary []string) string {
+	for _, name := range vary {
+		key += "\x00vary:" + name + "=" + strings.Join(r.Header.Values(name), ",")
+	}
+
+	return key
+}
+
+// newEntry returns the entry of a response, or false if it must not be stored
+func (c *cacheHandler) newEntry(status int, header http.Header, body []byte, endpoint *config.CacheConfig) (*cache.Entry, bool) {
+	if !cacheableStatuses[status] || header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	_, noStore := directives["no-store"]
+	_, private := directives["private"]
+	if noStore || private {
+		return nil, false
+	}
+
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil, false
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	lifetime, explicit := freshnessLifetime(directives, header)
+	if endpoint != nil && endpoint.TTL > 0 {
+		lifetime, explicit = endpoint.TTL, true
+	}
+
+	if !explicit {
+		lifetime = c.defaultTTL
+	}
+
+	_, noCache := directives["no-cache"]
+	if noCache {
+		lifetime = 0
+	}
+
+	entry := &cache.Entry{
+		Status:   status,
+		Header:   header.Clone(),
+		Body:     body,
+		Vary:     vary,
+		StoredAt: time.Now(),
+		Lifetime: lifetime,
+	}
+	entry.Header.Del(CacheHeader)
+	entry.Header.Del("Age")
+
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		entry.InitialAge = time.Duration(age) * time.Second
+	}
+
+	entry.StaleWhileRevalidate, _ = directiveSeconds(directives, "stale-while-revalidate")
+	entry.StaleIfError, _ = directiveSeconds(directives, "stale-if-error")
+	_, mustRevalidate := directives["must-revalidate"]
+	_, proxyRevalidate := directives["proxy-revalidate"]
+	entry.MustRevalidate = mustRevalidate || proxyRevalidate || noCache
+
+	// Responses without lifetime are only useful if they can be revalidated or used stale.
+	if lifetime <= 0 && !hasValidators(header) && (entry.MustRevalidate || entry.StaleWhileRevalidate <= 0 && entry.StaleIfError <= 0) {
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// freshnessLifetime returns the lifetime announced by the response, and whether it announced one
+func freshnessLifetime(directives map[string]string, header http.Header) (time.Duration, bool) {
+	if lifetime, ok := directiveSeconds(directives, "s-maxage"); ok {
+		return lifetime, true
+	}
+
+	if lifetime, ok := directiveSeconds(directives, "max-age"); ok {
+		return lifetime, true
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates, such as 0, represent a time in the past.
+			return 0, true
+		}
+
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+
+		return max(expiresAt.Sub(date), 0), true
+	}
+
+	return 0, false
+}
+
+// parseCacheControl returns the lowercase directives of Cache-Control headers with their argument
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, argument, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(argument, `"`)
+			}
+		}
+	}
+
+	return directives
+}
+
+// directiveSeconds returns the delta-seconds argument of a directive, invalid arguments being 0
+func directiveSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	argument, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+
+	seconds, err := strconv.Atoi(argument)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+func hasConditionals(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+func withConditionals(r *http.Request, entry *cache.Entry) *http.Request {
+	outreq := r.Clone(r.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		outreq.Header.Set("If-None-Match", etag)
+	}
+
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		outreq.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	return outreq
+}
+
+// serveEntry writes the cached response, as 304 Not Modified if the client already has it
+func serveEntry(w http.ResponseWriter, r *http.Request, entry *cache.Entry, now time.Time, status string) {
+	h := w.Header()
+	for name, values := range entry.Header {
+		h[name] = slices.Clone(values)
+	}
+
+	h.Set("Age", strconv.Itoa(int(entry.Age(now).Seconds())))
+	h.Set(CacheHeader, status)
+
+	if entry.Status == http.StatusOK && notModified(r, entry) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(entry.Status)
+	w.Write(entry.Body) //nolint:errcheck
+}
+
+// notModified evaluates the conditional headers of the request against the entry
+func notModified(r *http.Request, entry *cache.Entry) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := strings.TrimPrefix(entry.Header.Get("ETag"), "W/")
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ifModifiedSince, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lastModified, err := http.ParseTime(entry.Header.Get("Last-Modified"))
+	return err == nil && !lastModified.After(ifModifiedSince)
+}
+
+// cacheWriter records the response while writing it, unless it is intercepted to be
+// replaced by the cached response
+type cacheWriter struct {
+	http.ResponseWriter
+	// before are the headers set before the handler, which are not part of the response
+	before       http.Header
+	maxBodyBytes int
+	intercept    func(status int) bool
+
+	status      int
+	wroteHeader bool
+	intercepted bool
+	header      http.Header
+	body        []byte
+	overflow    bool
+}
+
+// WriteHeader records the headers of the response and decides whether to intercept it
+func (cw *cacheWriter) WriteHeader(code int) {
+	// Informational responses, such as 101 Switching Protocols, are not the final response.
+	if code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if cw.wroteHeader {
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.status = code
+	cw.header = cw.Header().Clone()
+	for name, values := range cw.before {
+		if slices.Equal(cw.header[name], values) {
+			delete(cw.header, name)
+		}
+	}
+
+	if cw.intercept(code) {
+		cw.intercepted = true
+		h := cw.Header()
+		clear(h)
+		maps.Copy(h, cw.before)
+		return
+	}
+
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+// Write records and writes the body, or discards it if the response is intercepted
+func (cw *cacheWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.intercepted {
+		return len(p), nil
+	}
+
+	if !cw.overflow {
+		if cw.maxBodyBytes > 0 && len(cw.body)+len(p) > cw.maxBodyBytes {
+			cw.overflow, cw.body = true, nil
+		} else {
+			cw.body = append(cw.body, p...)
+		}
+	}
+
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush sends what has been written so far
+func (cw *cacheWriter) Flush() {
+	if cw.intercepted {
+		return
+	}
+
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, e.g. to hijack upgraded connections
+func (cw *cacheWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// bufferedResponse records the responses of background revalidations
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.status == 0 && code >= http.StatusOK {
+		b.status = code
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+
+	return b.body.Write(p)
+}
@@ -0,0 +1,279 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/config"
+	internalMiddleware "github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/internal/router"
+	"github.com/arthurdotwork/heimdall/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheMiddleware(t *testing.T) {
+	t.Parallel()
+
+	// upstream counts its calls and writes the headers and status returned by respond
+	upstream := func(respond func(r *http.Request, calls int32) (int, http.Header)) (http.Handler, *atomic.Int32) {
+		var calls atomic.Int32
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, header := respond(r, calls.Add(1))
+			for name, values := range header {
+				w.Header()[name] = values
+			}
+
+			w.WriteHeader(status)
+			if status != http.StatusNotModified {
+				w.Write([]byte("response " + strconv.Itoa(int(calls.Load())))) //nolint:errcheck
+			}
+		}), &calls
+	}
+
+	newHandler := func(config *middleware.CacheConfig, next http.Handler) http.Handler {
+		return internalMiddleware.NewChain().Add(middleware.Cache(config)).Then(next)
+	}
+
+	get := func(handler http.Handler, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it should serve fresh responses from the cache", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		first := get(handler, nil)
+		require.Equal(t, "MISS", first.Header().Get("X-Cache"))
+		require.Equal(t, "response 1", first.Body.String())
+
+		second := get(handler, nil)
+		require.Equal(t, "HIT", second.Header().Get("X-Cache"))
+		require.Equal(t, "response 1", second.Body.String())
+		require.Equal(t, "max-age=60", second.Header().Get("Cache-Control"))
+		require.Equal(t, "0", second.Header().Get("Age"))
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("it should not store responses forbidding it", func(t *testing.T) {
+		for _, header := range []http.Header{
+			{"Cache-Control": {"no-store"}},
+			{"Cache-Control": {"private, max-age=60"}},
+			{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}},
+			{"Cache-Control": {"max-age=60"}, "Vary": {"*"}},
+			{},
+		} {
+			next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+				return http.StatusOK, header
+			})
+			handler := newHandler(nil, next)
+
+			get(handler, nil)
+			require.Equal(t, "MISS", get(handler, nil).Header().Get("X-Cache"))
+			require.Equal(t, int32(2), calls.Load())
+		}
+	})
+
+	t.Run("it should honor Expires", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			now := time.Now()
+			return http.StatusOK, http.Header{
+				"Date":    {now.UTC().Format(http.TimeFormat)},
+				"Expires": {now.Add(time.Hour).UTC().Format(http.TimeFormat)},
+			}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+		require.Equal(t, "HIT", get(handler, nil).Header().Get("X-Cache"))
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("it should store a variant per Vary header value", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}
+		})
+		handler := newHandler(nil, next)
+
+		english := http.Header{"Accept-Language": {"en"}}
+		french := http.Header{"Accept-Language": {"fr"}}
+
+		require.Equal(t, "response 1", get(handler, english).Body.String())
+		require.Equal(t, "response 2", get(handler, french).Body.String())
+		require.Equal(t, "response 1", get(handler, english).Body.String())
+		require.Equal(t, "response 2", get(handler, french).Body.String())
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("it should revalidate stale responses with their validators", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				return http.StatusNotModified, http.Header{"Etag": {`"v1"`}, "Cache-Control": {"max-age=60"}}
+			}
+
+			return http.StatusOK, http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-cache"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+
+		revalidated := get(handler, nil)
+		require.Equal(t, http.StatusOK, revalidated.Code)
+		require.Equal(t, "HIT", revalidated.Header().Get("X-Cache"))
+		require.Equal(t, "response 1", revalidated.Body.String())
+		require.Equal(t, "max-age=60", revalidated.Header().Get("Cache-Control"))
+
+		// The refreshed headers made the response fresh.
+		require.Equal(t, "HIT", get(handler, nil).Header().Get("X-Cache"))
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("it should answer conditional requests of clients with 304", func(t *testing.T) {
+		next, _ := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Etag": {`"v1"`}, "Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+
+		rec := get(handler, http.Header{"If-None-Match": {`W/"v0", "v1"`}})
+		require.Equal(t, http.StatusNotModified, rec.Code)
+		require.Empty(t, rec.Body.String())
+	})
+
+	t.Run("it should serve stale responses while revalidating them", func(t *testing.T) {
+		revalidated := make(chan struct{})
+		next, _ := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			if calls == 2 {
+				defer close(revalidated)
+			}
+
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=0, stale-while-revalidate=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+
+		stale := get(handler, nil)
+		require.Equal(t, "STALE", stale.Header().Get("X-Cache"))
+		require.Equal(t, "response 1", stale.Body.String())
+
+		<-revalidated
+		require.Eventually(t, func() bool {
+			return get(handler, nil).Body.String() == "response 2"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("it should serve stale responses when the upstream fails", func(t *testing.T) {
+		next, _ := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			if calls > 1 {
+				return http.StatusBadGateway, http.Header{"Content-Type": {"application/problem+json"}}
+			}
+
+			return http.StatusOK, http.Header{"Content-Type": {"text/plain"}, "Cache-Control": {"max-age=0, stale-if-error=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+
+		rec := get(handler, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+		require.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+		require.Equal(t, "response 1", rec.Body.String())
+	})
+
+	t.Run("it should bypass the cache for requests forbidding it", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+
+		require.Equal(t, "BYPASS", get(handler, http.Header{"Cache-Control": {"no-store"}}).Header().Get("X-Cache"))
+		require.Equal(t, "BYPASS", get(handler, http.Header{"Authorization": {"Bearer token"}}).Header().Get("X-Cache"))
+		require.Equal(t, "MISS", get(handler, http.Header{"Cache-Control": {"no-cache"}}).Header().Get("X-Cache"))
+		require.Equal(t, int32(4), calls.Load())
+	})
+
+	t.Run("it should invalidate cached responses on unsafe requests", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(nil, next)
+
+		get(handler, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/users", nil))
+
+		require.Equal(t, "MISS", get(handler, nil).Header().Get("X-Cache"))
+		require.Equal(t, int32(3), calls.Load())
+	})
+
+	t.Run("it should not cache responses larger than the maximum size", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(&middleware.CacheConfig{MaxBodyBytes: 4}, next)
+
+		require.Equal(t, "response 1", get(handler, nil).Body.String())
+		require.Equal(t, "MISS", get(handler, nil).Header().Get("X-Cache"))
+		require.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("it should use the key function", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}
+		})
+		handler := newHandler(&middleware.CacheConfig{KeyFunc: func(r *http.Request) string {
+			return r.URL.Path
+		}}, next)
+
+		for _, target := range []string{"/users?page=1", "/users?page=2"} {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		}
+
+		require.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("it should apply the cache settings of the endpoint", func(t *testing.T) {
+		next, calls := upstream(func(r *http.Request, calls int32) (int, http.Header) {
+			return http.StatusOK, http.Header{}
+		})
+		handler := newHandler(nil, next)
+
+		route := &router.Route{Cache: &config.CacheConfig{TTL: time.Minute, KeyHeaders: []string{"X-Tenant"}}}
+		serve := func(tenant string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/users", nil)
+			req.Header.Set("X-Tenant", tenant)
+			req = req.WithContext(router.WithRoute(req.Context(), route))
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec
+		}
+
+		require.Equal(t, "response 1", serve("a").Body.String())
+		require.Equal(t, "response 2", serve("b").Body.String())
+		require.Equal(t, "HIT", serve("a").Header().Get("X-Cache"))
+		require.Equal(t, int32(2), calls.Load())
+
+		disabled := &router.Route{Cache: &config.CacheConfig{Disabled: true}}
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req.WithContext(router.WithRoute(req.Context(), disabled)))
+		require.Empty(t, rec.Header().Get("X-Cache"))
+	})
+}
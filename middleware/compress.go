@@ -0,0 +1,382 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/arthurdotwork/heimdall"
+)
+
+// CompressConfig holds configuration for the compression middleware
+type CompressConfig struct {
+	// Level is the compression level (1-9), 0 uses the default level
+	Level int
+	// MinSize is the minimum size in bytes of the responses to compress
+	MinSize int
+	// ContentTypes are the media types to compress, "text/*" matches every text type
+	ContentTypes []string
+	// Decompress decodes gzip responses of upstreams for clients that don't accept gzip
+	Decompress bool
+}
+
+// DefaultCompressConfig returns a default compression configuration
+func DefaultCompressConfig() *CompressConfig {
+	return &CompressConfig{
+		MinSize: 1024,
+		ContentTypes: []string{
+			"text/html",
+			"text/css",
+			"text/plain",
+			"text/xml",
+			"text/javascript",
+			"application/javascript",
+			"application/json",
+			"application/problem+json",
+			"application/xml",
+			"image/svg+xml",
+		},
+		Decompress: true,
+	}
+}
+
+// Compress creates a middleware compressing responses with gzip or deflate, as negotiated
+// with the Accept-Encoding header of the client
+func Compress(config *CompressConfig) heimdall.Middleware {
+	if config == nil {
+		config = DefaultCompressConfig()
+	}
+
+	level := config.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	return heimdall.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &compressWriter{
+				ResponseWriter: w,
+				config:         config,
+				level:          level,
+				encoding:       negotiateEncoding(r.Header.Get("Accept-Encoding")),
+				acceptsGzip:    acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip"),
+				head:           r.Method == http.MethodHead,
+			}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	})
+}
+
+// negotiateEncoding returns the preferred encoding of the client among gzip and deflate
+func negotiateEncoding(acceptEncoding string) string {
+	gzipQ, deflateQ := encodingQuality(acceptEncoding, "gzip"), encodingQuality(acceptEncoding, "deflate")
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	return encodingQuality(acceptEncoding, encoding) > 0
+}
+
+// encodingQuality returns the q-value of an encoding in an Accept-Encoding header
+func encodingQuality(acceptEncoding, encoding string) float64 {
+	quality, wildcard := -1.0, 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+
+		switch {
+		case strings.EqualFold(name, encoding):
+			quality = q
+		case name == "*":
+			wildcard = q
+		}
+	}
+
+	if quality < 0 {
+		return wildcard
+	}
+
+	return quality
+}
+
+type compressMode int
+
+const (
+	modeUndecided compressMode = iota
+	modeIdentity
+	modeCompress
+	modeDecompress
+)
+
+// compressWriter buffers the beginning of the response until it knows whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	config      *CompressConfig
+	level       int
+	encoding    string
+	acceptsGzip bool
+	head        bool
+
+	status      int
+	wroteHeader bool
+	mode        compressMode
+	buf         []byte
+
+	compressor interface {
+		io.WriteCloser
+		Flush() error
+	}
+
+	// decompression writes to a pipe read by a goroutine writing to the client
+	mutex    sync.Mutex
+	pipe     *io.PipeWriter
+	pipeDone chan struct{}
+}
+
+// WriteHeader decides on the compression as soon as the headers allow it
+func (cw *compressWriter) WriteHeader(code int) {
+	// Informational responses, such as 101 Switching Protocols, are not the final response.
+	if code < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if cw.wroteHeader {
+		return
+	}
+
+	cw.wroteHeader = true
+	cw.status = code
+
+	mode := cw.decide()
+	if mode == modeCompress && cw.config.MinSize > 0 && cw.Header().Get("Content-Length") == "" {
+		// The size is unknown: the beginning of the body is buffered until it reaches the minimum size.
+		return
+	}
+
+	cw.start(mode)
+}
+
+// decide returns the mode the response allows, compression being subject to its size
+func (cw *compressWriter) decide() compressMode {
+	h := cw.Header()
+	switch cw.status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return modeIdentity
+	}
+
+	if cw.head {
+		return modeIdentity
+	}
+
+	if encoding := h.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		if strings.EqualFold(encoding, "gzip") && !cw.acceptsGzip && cw.config.Decompress {
+			return modeDecompress
+		}
+
+		return modeIdentity
+	}
+
+	if !cw.compressible(h.Get("Content-Type")) || strings.Contains(h.Get("Cache-Control"), "no-transform") {
+		return modeIdentity
+	}
+
+	// The representation now depends on the Accept-Encoding header of the request.
+	h.Add("Vary", "Accept-Encoding")
+
+	if cw.encoding == "" {
+		return modeIdentity
+	}
+
+	if length := h.Get("Content-Length"); length != "" {
+		if n, err := strconv.Atoi(length); err == nil && n < cw.config.MinSize {
+			return modeIdentity
+		}
+	}
+
+	return modeCompress
+}
+
+func (cw *compressWriter) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range cw.config.ContentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+
+			continue
+		}
+
+		if strings.EqualFold(mediaType, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// start writes the headers of the response in the given mode, then the buffered body
+func (cw *compressWriter) start(mode compressMode) {
+	cw.mode = mode
+	h := cw.Header()
+
+	switch mode {
+	case modeCompress:
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		weakenETag(h)
+
+		if cw.encoding == "gzip" {
+			cw.compressor, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		} else {
+			cw.compressor, _ = zlib.NewWriterLevel(cw.ResponseWriter, cw.level)
+		}
+	case modeDecompress:
+		h.Del("Content-Encoding")
+		h.Del("Content-Length")
+		h.Add("Vary", "Accept-Encoding")
+		weakenETag(h)
+		cw.startDecompression()
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) > 0 {
+		buf := cw.buf
+		cw.buf = nil
+		cw.write(buf) //nolint:errcheck
+	}
+}
+
+// weakenETag makes a strong ETag weak: the encoded body is not byte-identical to the one
+// the upstream tagged, while it stays semantically equivalent.
+func weakenETag(h http.Header) {
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+}
+
+func (cw *compressWriter) startDecompression() {
+	pr, pw := io.Pipe()
+	cw.pipe = pw
+	cw.pipeDone = make(chan struct{})
+
+	go func() {
+		defer close(cw.pipeDone)
+
+		zr, err := gzip.NewReader(pr)
+		if err != nil {
+			pr.CloseWithError(err) //nolint:errcheck
+			return
+		}
+
+		_, err = io.Copy(writerFunc(func(p []byte) (int, error) {
+			cw.mutex.Lock()
+			defer cw.mutex.Unlock()
+			return cw.ResponseWriter.Write(p)
+		}), zr)
+		pr.CloseWithError(err) //nolint:errcheck
+	}()
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// Write buffers, compresses or writes the body depending on the mode
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		if cw.Header().Get("Content-Type") == "" && cw.Header().Get("Content-Encoding") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.mode == modeUndecided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) >= cw.config.MinSize {
+			cw.start(modeCompress)
+		}
+
+		return len(p), nil
+	}
+
+	return cw.write(p)
+}
+
+func (cw *compressWriter) write(p []byte) (int, error) {
+	switch cw.mode {
+	case modeCompress:
+		return cw.compressor.Write(p)
+	case modeDecompress:
+		return cw.pipe.Write(p)
+	default:
+		return cw.ResponseWriter.Write(p)
+	}
+}
+
+// Flush sends what has been written so far, compressing it if the size is still unknown
+func (cw *compressWriter) Flush() {
+	if cw.wroteHeader && cw.mode == modeUndecided {
+		cw.start(modeCompress)
+	}
+
+	if cw.compressor != nil {
+		cw.compressor.Flush() //nolint:errcheck
+	}
+
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying ResponseWriter, e.g. to hijack upgraded connections
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close ends the response once the handler returned
+func (cw *compressWriter) close() {
+	if !cw.wroteHeader {
+		return
+	}
+
+	switch cw.mode {
+	case modeUndecided:
+		// The whole body is smaller than the minimum size.
+		cw.start(modeIdentity)
+	case modeCompress:
+		cw.compressor.Close() //nolint:errcheck
+	case modeDecompress:
+		cw.pipe.Close() //nolint:errcheck
+		<-cw.pipeDone
+	}
+}
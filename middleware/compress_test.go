@@ -0,0 +1,245 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	internalMiddleware "github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	t.Parallel()
+
+	largeBody := strings.Repeat(`{"name":"heimdall"}`, 100)
+
+	serve := func(config *middleware.CompressConfig, handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		internalMiddleware.NewChain().Add(middleware.Compress(config)).Then(handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	jsonHandler := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body)) //nolint:errcheck
+		}
+	}
+
+	t.Run("it should compress responses with gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip")
+
+		rec := serve(nil, jsonHandler(largeBody), req)
+
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		require.Empty(t, rec.Header().Get("Content-Length"))
+
+		zr, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.Equal(t, largeBody, string(body))
+	})
+
+	t.Run("it should weaken the strong ETag of compressed responses", func(t *testing.T) {
+		for etag, expected := range map[string]string{`"v1"`: `W/"v1"`, `W/"v1"`: `W/"v1"`} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			rec := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", etag)
+				jsonHandler(largeBody)(w, r)
+			}, req)
+
+			require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+			require.Equal(t, expected, rec.Header().Get("ETag"))
+		}
+
+		rec := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			jsonHandler(largeBody)(w, r)
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Equal(t, `"v1"`, rec.Header().Get("ETag"))
+	})
+
+	t.Run("it should compress responses with deflate when preferred", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0.5, deflate")
+
+		rec := serve(nil, jsonHandler(largeBody), req)
+
+		require.Equal(t, "deflate", rec.Header().Get("Content-Encoding"))
+
+		zr, err := zlib.NewReader(rec.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		require.Equal(t, largeBody, string(body))
+	})
+
+	t.Run("it should not compress small responses", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := serve(nil, jsonHandler(`{"ok":true}`), req)
+
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		require.Equal(t, `{"ok":true}`, rec.Body.String())
+	})
+
+	t.Run("it should not compress content types outside the allowlist", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(largeBody)) //nolint:errcheck
+		}, req)
+
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Empty(t, rec.Header().Get("Vary"))
+		require.Equal(t, largeBody, rec.Body.String())
+	})
+
+	t.Run("it should not compress when the client does not accept it", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip;q=0, br")
+
+		rec := serve(nil, jsonHandler(largeBody), req)
+
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Equal(t, largeBody, rec.Body.String())
+	})
+
+	t.Run("it should skip already encoded responses", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+
+		rec := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte(largeBody)) //nolint:errcheck
+		}, req)
+
+		require.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+		require.Equal(t, largeBody, rec.Body.String())
+	})
+
+	t.Run("it should decompress gzip responses for clients that don't accept gzip", func(t *testing.T) {
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		zw.Write([]byte(largeBody)) //nolint:errcheck
+		zw.Close()                  //nolint:errcheck
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		rec := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Set("Content-Length", "42")
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(compressed.Bytes()) //nolint:errcheck
+		}, req)
+
+		require.Equal(t, `W/"v1"`, rec.Header().Get("ETag"))
+
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+		require.Empty(t, rec.Header().Get("Content-Length"))
+		require.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		require.Equal(t, largeBody, rec.Body.String())
+	})
+
+	t.Run("it should compress flushed chunks of streamed responses", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		var flushed []byte
+		rec := httptest.NewRecorder()
+		handler := internalMiddleware.NewChain().Add(middleware.Compress(nil)).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("first chunk")) //nolint:errcheck
+			require.NoError(t, http.NewResponseController(w).Flush())
+
+			flushed = bytes.Clone(rec.Body.Bytes())
+			w.Write([]byte(" second chunk")) //nolint:errcheck
+		}))
+		handler.ServeHTTP(rec, req)
+
+		require.True(t, rec.Flushed)
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+
+		// What was flushed decodes to the first chunk without the end of the stream.
+		partial, _ := io.ReadAll(mustGzipReader(t, flushed))
+		require.Equal(t, "first chunk", string(partial))
+
+		body, err := io.ReadAll(mustGzipReader(t, rec.Body.Bytes()))
+		require.NoError(t, err)
+		require.Equal(t, "first chunk second chunk", string(body))
+	})
+
+	t.Run("it should pass responses without body through", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := serve(&middleware.CompressConfig{MinSize: 0, ContentTypes: []string{"text/*"}}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusNoContent)
+		}, req)
+
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("it should let upgraded connections be hijacked", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+		handler := internalMiddleware.NewChain().Add(middleware.Compress(nil)).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Upgrade", "websocket")
+			w.WriteHeader(http.StatusSwitchingProtocols)
+
+			conn, _, err := http.NewResponseController(w).Hijack()
+			require.NoError(t, err)
+			conn.Close() //nolint:errcheck
+		}))
+		handler.ServeHTTP(rec, req)
+
+		require.True(t, rec.hijacked)
+		require.Equal(t, http.StatusSwitchingProtocols, rec.Code)
+		require.Empty(t, rec.Header().Get("Content-Encoding"))
+	})
+
+	t.Run("it should match wildcard content types", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "*")
+
+		rec := serve(&middleware.CompressConfig{ContentTypes: []string{"text/*"}}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Write([]byte("a,b")) //nolint:errcheck
+		}, req)
+
+		require.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	})
+}
+
+func mustGzipReader(t *testing.T, data []byte) io.Reader {
+	t.Helper()
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	return zr
+}
@@ -12,6 +12,7 @@ import (
 type CORSConfig struct {
 	AllowMethods     []string
 	AllowHeaders     []string
+	ExposeHeaders    []string
 	AllowCredentials bool
 	MaxAge           int
 }
@@ -38,6 +39,25 @@ func DefaultCORSConfig() *CORSConfig {
 	}
 }
 
+// GRPCWebCORSConfig returns a CORS configuration suited to gRPC-Web browser clients:
+// it accepts the gRPC-Web request headers and exposes the gRPC status headers.
+func GRPCWebCORSConfig() *CORSConfig {
+	config := DefaultCORSConfig()
+	config.AllowMethods = []string{http.MethodPost, http.MethodOptions}
+	config.AllowHeaders = append(config.AllowHeaders,
+		"X-Grpc-Web",
+		"X-User-Agent",
+		"Grpc-Timeout",
+	)
+	config.ExposeHeaders = []string{
+		"Grpc-Status",
+		"Grpc-Message",
+		"Grpc-Status-Details-Bin",
+	}
+
+	return config
+}
+
 // CORS creates a middleware for handling Cross-Origin Resource Sharing
 func CORS(config *CORSConfig) heimdall.Middleware {
 	if config == nil {
@@ -46,6 +66,7 @@ func CORS(config *CORSConfig) heimdall.Middleware {
 
 	allowMethods := config.AllowMethods
 	allowHeaders := config.AllowHeaders
+	exposeHeaders := config.ExposeHeaders
 	allowCredentials := config.AllowCredentials
 	maxAge := config.MaxAge
 
@@ -89,6 +110,10 @@ func CORS(config *CORSConfig) heimdall.Middleware {
 				header.Set("Access-Control-Allow-Credentials", "true")
 			}
 
+			if len(exposeHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(exposeHeaders, ", "))
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	})
@@ -80,4 +80,31 @@ func TestCORSMiddleware(t *testing.T) {
 		require.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "Content-Type")
 		require.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
 	})
+
+	t.Run("it should expose the gRPC-Web headers", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		chain := internalMiddleware.NewChain().Add(middleware.CORS(middleware.GRPCWebCORSConfig()))
+		finalHandler := chain.Then(handler)
+
+		req := httptest.NewRequest(http.MethodOptions, "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Origin", "http://example.com")
+		rec := httptest.NewRecorder()
+
+		finalHandler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusNoContent, rec.Code)
+		require.Contains(t, rec.Header().Get("Access-Control-Allow-Headers"), "X-Grpc-Web")
+
+		req = httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+		req.Header.Set("Origin", "http://example.com")
+		rec = httptest.NewRecorder()
+
+		finalHandler.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin", rec.Header().Get("Access-Control-Expose-Headers"))
+	})
 }
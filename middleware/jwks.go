@@ -0,0 +1,297 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJWKSRefreshInterval is the interval between refreshes of the key sets by default
+	defaultJWKSRefreshInterval = time.Hour
+	// minJWKSRefreshInterval limits the refreshes triggered by tokens signed by unknown keys
+	minJWKSRefreshInterval = 10 * time.Second
+	// maxJWKSBytes bounds the size of the key sets
+	maxJWKSBytes = 1 << 20
+)
+
+// keySet is a JSON Web Key Set fetched from a URL or read from a file, refreshed periodically
+// and when a token is signed by an unknown key. Refreshes run outside the lock, one at a time.
+type keySet struct {
+	url      string
+	file     string
+	interval time.Duration
+	client   *http.Client
+
+	mutex       sync.Mutex
+	keys        map[string][]any
+	refreshed   time.Time
+	attempted   time.Time
+	fileModTime time.Time
+	// refreshing is closed when the refresh in progress, if any, completes
+	refreshing chan struct{}
+}
+
+func newKeySet(url, file string, interval time.Duration, client *http.Client) *keySet {
+	if interval == 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &keySet{url: url, file: file, interval: interval, client: client}
+}
+
+// lookup returns the keys of the key ID, or every key if keyID is empty. Stale keys are served
+// while they are refreshed, unknown ones wait for the refresh.
+func (s *keySet) lookup(ctx context.Context, keyID string) []any {
+	s.mutex.Lock()
+	now := time.Now()
+	stale := now.Sub(s.refreshed) >= s.interval
+	known := s.known(keyID)
+
+	if (stale || !known) && s.refreshing == nil && now.Sub(s.attempted) >= min(minJWKSRefreshInterval, s.interval) {
+		s.attempted = now
+		s.refreshing = make(chan struct{})
+		go s.refresh(context.WithoutCancel(ctx), s.keys != nil, s.fileModTime, s.refreshing)
+	}
+
+	refreshing := s.refreshing
+	s.mutex.Unlock()
+
+	if !known && refreshing != nil {
+		select {
+		case <-refreshing:
+		case <-ctx.Done():
+		}
+	}
+
+	s.mutex.Lock()
+	keys := s.keys
+	s.mutex.Unlock()
+
+	if keyID != "" {
+		return keys[keyID]
+	}
+
+	var all []any
+	for _, byID := range keys {
+		all = append(all, byID...)
+	}
+
+	return all
+}
+
+// known reports whether the current keys include the key ID, or any key if keyID is empty
+func (s *keySet) known(keyID string) bool {
+	if keyID == "" {
+		return len(s.keys) > 0
+	}
+
+	_, ok := s.keys[keyID]
+	return ok
+}
+
+// refresh replaces the keys with the loaded ones and closes done
+func (s *keySet) refresh(ctx context.Context, loaded bool, fileModTime time.Time, done chan struct{}) {
+	keys, modTime, err := s.load(ctx, loaded, fileModTime)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		// The keys in use remain valid until the key set can be refreshed.
+		slog.ErrorContext(ctx, "failed to refresh JWKS", "url", s.url, "file", s.file, "error", err)
+	} else {
+		if keys != nil {
+			s.keys, s.fileModTime = keys, modTime
+		}
+
+		s.refreshed = time.Now()
+	}
+
+	s.refreshing = nil
+	close(done)
+}
+
+// load fetches the key set, the file one being read again only when it changed since fileModTime.
+// It returns nil keys when the file did not change.
+func (s *keySet) load(ctx context.Context, loaded bool, fileModTime time.Time) (map[string][]any, time.Time, error) {
+	var data []byte
+	if s.url != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		defer resp.Body.Close() //nolint:errcheck
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, time.Time{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxJWKSBytes)); err != nil {
+			return nil, time.Time{}, err
+		}
+	} else {
+		info, err := os.Stat(s.file)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if loaded && info.ModTime().Equal(fileModTime) {
+			return nil, fileModTime, nil
+		}
+
+		if data, err = os.ReadFile(s.file); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		fileModTime = info.ModTime()
+	}
+
+	keys, err := parseJWKS(data, s.url == "")
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return keys, fileModTime, nil
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+	K       string `json:"k"`
+}
+
+// parseJWKS returns the signature keys of a key set by key ID, ignoring the unsupported ones.
+// Symmetric keys are secrets, only trusted from local files: published ones would let anyone sign tokens.
+func parseJWKS(data []byte, symmetric bool) (map[string][]any, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string][]any, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if jwk.KeyType == "oct" && !symmetric {
+			slog.Warn("ignoring symmetric JWKS key fetched from a URL", "kid", jwk.KeyID)
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			slog.Warn("ignoring JWKS key", "kid", jwk.KeyID, "error", err)
+			continue
+		}
+
+		keys[jwk.KeyID] = append(keys[jwk.KeyID], key)
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.KeyType {
+	case "oct":
+		return decodeKeyParam(k.K)
+	case "RSA":
+		n, err := decodeKeyParam(k.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeKeyParam(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := decodeKeyParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeKeyParam(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+
+		// Parsing the uncompressed point checks that it is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+
+		x, err := decodeKeyParam(k.X)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func decodeKeyParam(value string) ([]byte, error) {
+	if value == "" {
+		return nil, errors.New("missing key parameter")
+	}
+
+	return base64.RawURLEncoding.DecodeString(value)
+}
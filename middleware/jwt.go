@@ -0,0 +1,429 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arthurdotwork/heimdall"
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	"github.com/arthurdotwork/heimdall/internal/problem"
+	"github.com/arthurdotwork/heimdall/internal/router"
+)
+
+// Signature algorithms supported by the JWT middleware
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// JWTConfig holds configuration for the JWT middleware
+type JWTConfig struct {
+	// Keys verify the tokens by key ID, the "kid" header of the token. Tokens without key ID are
+	// verified with every key of their algorithm. Keys are []byte HMAC secrets, *rsa.PublicKey,
+	// *ecdsa.PublicKey (P-256) or ed25519.PublicKey.
+	Keys map[string]any
+	// JWKSURL and JWKSFile are JSON Web Key Sets whose keys are added to the static keys.
+	// Symmetric (oct) keys are only read from JWKSFile, the ones of JWKSURL are ignored.
+	JWKSURL  string
+	JWKSFile string
+	// JWKSRefreshInterval is the interval between refreshes of the key sets, 1 hour by default.
+	// Tokens signed by an unknown key ID refresh them sooner, to follow key rotations.
+	JWKSRefreshInterval time.Duration
+	// HTTPClient fetches JWKSURL, a client with a 10 seconds timeout by default
+	HTTPClient *http.Client
+	// Algorithms are the accepted signature algorithms, all the supported ones by default
+	Algorithms []string
+	// Issuer is the required "iss" claim, any issuer by default
+	Issuer string
+	// Audience lists the accepted "aud" claims, the token must have one of them, any audience by default
+	Audience []string
+	// ClockSkew is the tolerance applied to the "exp" and "nbf" claims, 30 seconds by default
+	ClockSkew time.Duration
+	// RequiredScopes must all be granted by the "scope" (space-separated) or "scp" claim
+	RequiredScopes []string
+	// ForwardClaims maps claims, nested ones with dots (e.g. "org.id"), to the request headers
+	// carrying them upstream. Client values of these headers are never forwarded.
+	ForwardClaims map[string]string
+}
+
+type claimsContextKey struct{}
+
+// JWTClaims returns the claims of the token authenticated by the JWT middleware
+func JWTClaims(ctx context.Context) (map[string]any, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(map[string]any)
+	return claims, ok
+}
+
+// JWT creates a middleware authenticating requests with a bearer JSON Web Token in the
+// Authorization header. Invalid tokens are rejected with a 401, missing scopes with a 403.
+// Keys or a JWKS source are required: without them, as with a nil config, every token is rejected.
+func JWT(config *JWTConfig) heimdall.Middleware {
+	if config == nil {
+		config = &JWTConfig{}
+	}
+
+	if len(config.Keys) == 0 && config.JWKSURL == "" && config.JWKSFile == "" {
+		slog.Warn("JWT middleware has no keys, every token will be rejected")
+	}
+
+	v := &jwtValidator{
+		keys:       config.Keys,
+		algorithms: config.Algorithms,
+		issuer:     config.Issuer,
+		audience:   config.Audience,
+		clockSkew:  config.ClockSkew,
+		scopes:     config.RequiredScopes,
+		forward:    config.ForwardClaims,
+		now:        time.Now,
+	}
+	if len(v.algorithms) == 0 {
+		v.algorithms = []string{AlgorithmHS256, AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}
+	}
+	if v.clockSkew == 0 {
+		v.clockSkew = 30 * time.Second
+	}
+
+	if config.JWKSURL != "" || config.JWKSFile != "" {
+		v.keySet = newKeySet(config.JWKSURL, config.JWKSFile, config.JWKSRefreshInterval, config.HTTPClient)
+	}
+
+	return heimdall.MiddlewareFunc(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r)
+			if !ok {
+				writeAuthError(w, r, http.StatusUnauthorized, `Bearer`, "Missing bearer token")
+				return
+			}
+
+			claims, err := v.validate(r.Context(), token)
+			if err != nil {
+				slog.DebugContext(r.Context(), "invalid token", "path", r.URL.Path, "error", err)
+				writeAuthError(w, r, http.StatusUnauthorized, `Bearer error="invalid_token"`, "Invalid token")
+				return
+			}
+
+			if missing := missingScopes(claims, v.scopes); len(missing) > 0 {
+				writeAuthError(w, r, http.StatusForbidden, fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, strings.Join(v.scopes, " ")), "Missing scopes: "+strings.Join(missing, ", "))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+			if len(v.forward) > 0 {
+				ctx = headers.WithUpstream(ctx, forwardedClaims(claims, v.forward))
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+}
+
+// bearerToken returns the token of the Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// writeAuthError writes a problem with the error renderer of the route
+func writeAuthError(w http.ResponseWriter, r *http.Request, status int, challenge, detail string) {
+	code := problem.CodeUnauthorized
+	if status == http.StatusForbidden {
+		code = problem.CodeForbidden
+	}
+
+	var renderer *problem.Renderer
+	if route, ok := router.RouteFromContext(r.Context()); ok {
+		renderer = route.Errors
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	renderer.Render(w, r, problem.New(status, code, detail))
+}
+
+type jwtValidator struct {
+	keys       map[string]any
+	keySet     *keySet
+	algorithms []string
+	issuer     string
+	audience   []string
+	clockSkew  time.Duration
+	scopes     []string
+	forward    map[string]string
+	now        func() time.Time
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// validate verifies the signature and the registered claims of token, and returns its claims
+func (v *jwtValidator) validate(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+
+	if !slices.Contains(v.algorithms, header.Algorithm) {
+		return nil, fmt.Errorf("algorithm %q not accepted", header.Algorithm)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if !slices.ContainsFunc(v.candidateKeys(ctx, header.KeyID), func(key any) bool {
+		return verifySignature(header.Algorithm, key, signed, signature)
+	}) {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+
+	return claims, v.validateClaims(claims)
+}
+
+// candidateKeys returns the keys of the key ID, or every key if the token has none
+func (v *jwtValidator) candidateKeys(ctx context.Context, keyID string) []any {
+	var keys []any
+	if keyID == "" {
+		for _, key := range v.keys {
+			keys = append(keys, key)
+		}
+	} else if key, ok := v.keys[keyID]; ok {
+		keys = append(keys, key)
+	}
+
+	if v.keySet != nil {
+		keys = append(keys, v.keySet.lookup(ctx, keyID)...)
+	}
+
+	return keys
+}
+
+func (v *jwtValidator) validateClaims(claims map[string]any) error {
+	now := v.now()
+
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(v.clockSkew)) {
+		return errors.New("token expired")
+	}
+
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(v.clockSkew).Before(nbf) {
+		return errors.New("token not valid yet")
+	}
+
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return fmt.Errorf("issuer %v not accepted", claims["iss"])
+	}
+
+	if len(v.audience) > 0 && !slices.ContainsFunc(stringsClaim(claims["aud"]), func(aud string) bool {
+		return slices.Contains(v.audience, aud)
+	}) {
+		return fmt.Errorf("audience %v not accepted", claims["aud"])
+	}
+
+	return nil
+}
+
+// numericDate returns the date of a NumericDate claim, and false if the claim is absent
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("claim %s is not a number", name)
+	}
+
+	return time.UnixMilli(int64(seconds * 1000)), true, nil
+}
+
+// stringsClaim returns the values of a claim holding a string or an array of strings
+func stringsClaim(value any) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+// missingScopes returns the required scopes not granted by the "scope" or "scp" claim
+func missingScopes(claims map[string]any, required []string) []string {
+	var granted []string
+	if scope, ok := claims["scope"].(string); ok {
+		granted = strings.Fields(scope)
+	}
+
+	for _, scp := range stringsClaim(claims["scp"]) {
+		granted = append(granted, strings.Fields(scp)...)
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
+
+// forwardedClaims returns the headers carrying the claims upstream. Headers of absent claims
+// are sent empty, so that the client values are removed.
+func forwardedClaims(claims map[string]any, forward map[string]string) http.Header {
+	header := make(http.Header, len(forward))
+	for claim, name := range forward {
+		value, ok := lookupClaim(claims, claim)
+		if !ok {
+			header[http.CanonicalHeaderKey(name)] = nil
+			continue
+		}
+
+		header.Set(name, formatClaim(value))
+	}
+
+	return header
+}
+
+// lookupClaim returns the claim of a dotted path
+func lookupClaim(claims map[string]any, path string) (any, bool) {
+	var value any = claims
+	for name := range strings.SplitSeq(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// formatClaim formats strings as is, arrays of strings comma-separated and other values as JSON
+func formatClaim(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case []any:
+		if values := stringsClaim(value); len(values) == len(value) {
+			return strings.Join(values, ",")
+		}
+	}
+
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+// verifySignature reports whether signature is valid for the algorithm, the key being of its type
+func verifySignature(algorithm string, key any, signed, signature []byte) bool {
+	switch algorithm {
+	case AlgorithmHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+
+		return hmac.Equal(mac.Sum(nil), signature)
+	case AlgorithmRS256:
+		publicKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+
+		digest := sha256.Sum256(signed)
+
+		return rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	case AlgorithmES256:
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || publicKey.Curve.Params().Name != "P-256" || len(signature) != 64 {
+			return false
+		}
+
+		digest := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+
+		return ecdsa.Verify(publicKey, digest[:], r, s)
+	case AlgorithmEdDSA:
+		publicKey, ok := key.(ed25519.PublicKey)
+		if !ok || len(publicKey) != ed25519.PublicKeySize {
+			return false
+		}
+
+		return ed25519.Verify(publicKey, signed, signature)
+	}
+
+	return false
+}
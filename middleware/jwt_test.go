@@ -0,0 +1,383 @@
+package middleware_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arthurdotwork/heimdall/internal/headers"
+	internalMiddleware "github.com/arthurdotwork/heimdall/internal/middleware"
+	"github.com/arthurdotwork/heimdall/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+// signJWT returns a token of the claims signed with key by the algorithm
+func signJWT(t *testing.T, algorithm, keyID string, key any, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "typ": "JWT", "kid": keyID})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case middleware.AlgorithmHS256:
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case middleware.AlgorithmRS256:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case middleware.AlgorithmES256:
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case middleware.AlgorithmEdDSA:
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	validClaims := func() map[string]any {
+		return map[string]any{
+			"sub":   "user-1",
+			"iss":   "https://issuer",
+			"aud":   []string{"api"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "read write",
+			"org":   map[string]any{"id": "acme"},
+		}
+	}
+
+	// serve sends a request with the token to the middleware, recording the forwarded headers
+	serve := func(config *middleware.JWTConfig, token string) (*httptest.ResponseRecorder, http.Header) {
+		var forwarded http.Header
+		handler := internalMiddleware.NewChain().Add(middleware.JWT(config)).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded = headers.UpstreamFromContext(r.Context())
+			claims, ok := middleware.JWTClaims(r.Context())
+			if ok {
+				w.Write([]byte(claims["sub"].(string))) //nolint:errcheck
+			}
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		return recorder, forwarded
+	}
+
+	t.Run("it should accept tokens of every supported algorithm", func(t *testing.T) {
+		config := &middleware.JWTConfig{Keys: map[string]any{
+			"hmac":    secret,
+			"rsa":     &rsaKey.PublicKey,
+			"ec":      &ecKey.PublicKey,
+			"ed25519": edPublic,
+		}}
+
+		for _, tc := range []struct {
+			algorithm, keyID string
+			key              any
+		}{
+			{middleware.AlgorithmHS256, "hmac", secret},
+			{middleware.AlgorithmRS256, "rsa", rsaKey},
+			{middleware.AlgorithmES256, "ec", ecKey},
+			{middleware.AlgorithmEdDSA, "ed25519", edKey},
+		} {
+			recorder, _ := serve(config, signJWT(t, tc.algorithm, tc.keyID, tc.key, validClaims()))
+			require.Equal(t, http.StatusOK, recorder.Code, tc.algorithm)
+			require.Equal(t, "user-1", recorder.Body.String(), tc.algorithm)
+		}
+	})
+
+	t.Run("it should reject missing and invalid tokens", func(t *testing.T) {
+		config := &middleware.JWTConfig{Keys: map[string]any{"hmac": secret, "rsa": &rsaKey.PublicKey}}
+
+		recorder, _ := serve(config, "")
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+		require.Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+		require.Contains(t, recorder.Body.String(), `"code":"unauthorized"`)
+
+		for _, token := range []string{
+			"not-a-token",
+			signJWT(t, middleware.AlgorithmHS256, "hmac", []byte("other secret"), validClaims()),
+			// The RSA public key must not be usable as an HMAC secret.
+			signJWT(t, middleware.AlgorithmHS256, "rsa", []byte("whatever"), validClaims()),
+			signJWT(t, middleware.AlgorithmHS256, "unknown", secret, validClaims()),
+			signJWT(t, "none", "hmac", secret, validClaims()),
+		} {
+			recorder, _ := serve(config, token)
+			require.Equal(t, http.StatusUnauthorized, recorder.Code, token)
+			require.Equal(t, `Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"))
+		}
+	})
+
+	t.Run("it should reject every token without keys", func(t *testing.T) {
+		recorder, _ := serve(nil, signJWT(t, middleware.AlgorithmHS256, "", []byte{}, validClaims()))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("it should validate the registered claims with clock skew", func(t *testing.T) {
+		config := &middleware.JWTConfig{
+			Keys:      map[string]any{"": secret},
+			Issuer:    "https://issuer",
+			Audience:  []string{"api", "admin"},
+			ClockSkew: time.Minute,
+		}
+
+		for _, tc := range []struct {
+			name   string
+			claim  string
+			value  any
+			status int
+		}{
+			{"recently expired", "exp", time.Now().Add(-30 * time.Second).Unix(), http.StatusOK},
+			{"expired", "exp", time.Now().Add(-2 * time.Minute).Unix(), http.StatusUnauthorized},
+			{"almost valid", "nbf", time.Now().Add(30 * time.Second).Unix(), http.StatusOK},
+			{"not valid yet", "nbf", time.Now().Add(2 * time.Minute).Unix(), http.StatusUnauthorized},
+			{"wrong issuer", "iss", "https://other", http.StatusUnauthorized},
+			{"single audience", "aud", "admin", http.StatusOK},
+			{"wrong audience", "aud", []string{"web"}, http.StatusUnauthorized},
+			{"invalid expiration", "exp", "tomorrow", http.StatusUnauthorized},
+		} {
+			claims := validClaims()
+			claims[tc.claim] = tc.value
+
+			recorder, _ := serve(config, signJWT(t, middleware.AlgorithmHS256, "", secret, claims))
+			require.Equal(t, tc.status, recorder.Code, tc.name)
+		}
+	})
+
+	t.Run("it should require the configured scopes", func(t *testing.T) {
+		config := &middleware.JWTConfig{Keys: map[string]any{"": secret}, RequiredScopes: []string{"read", "admin"}}
+
+		recorder, _ := serve(config, signJWT(t, middleware.AlgorithmHS256, "", secret, validClaims()))
+		require.Equal(t, http.StatusForbidden, recorder.Code)
+		require.Equal(t, `Bearer error="insufficient_scope", scope="read admin"`, recorder.Header().Get("WWW-Authenticate"))
+		require.Contains(t, recorder.Body.String(), "Missing scopes: admin")
+
+		claims := validClaims()
+		claims["scp"] = []string{"admin"}
+		recorder, _ = serve(config, signJWT(t, middleware.AlgorithmHS256, "", secret, claims))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("it should forward the selected claims upstream", func(t *testing.T) {
+		config := &middleware.JWTConfig{
+			Keys:          map[string]any{"": secret},
+			ForwardClaims: map[string]string{"sub": "X-User-Id", "org.id": "X-Org-Id", "aud": "X-Audience", "email": "X-User-Email"},
+		}
+
+		recorder, forwarded := serve(config, signJWT(t, middleware.AlgorithmHS256, "", secret, validClaims()))
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, "user-1", forwarded.Get("X-User-Id"))
+		require.Equal(t, "acme", forwarded.Get("X-Org-Id"))
+		require.Equal(t, "api", forwarded.Get("X-Audience"))
+
+		// Absent claims clear the header, so that clients can not set it.
+		values, ok := forwarded["X-User-Email"]
+		require.True(t, ok)
+		require.Empty(t, values)
+	})
+
+	t.Run("it should fetch the keys of a JWKS endpoint", func(t *testing.T) {
+		var current atomic.Value
+		current.Store("rsa-1")
+		var fetches atomic.Int32
+
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches.Add(1)
+
+			key := &rsaKey.PublicKey
+			if current.Load() == "rsa-2" {
+				key = &otherKey.PublicKey
+			}
+
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{ //nolint:errcheck
+				{
+					"kty": "RSA",
+					"kid": current.Load().(string),
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+				{
+					"kty": "EC",
+					"kid": "ec",
+					"crv": "P-256",
+					"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+					"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+				},
+			}})
+		}))
+		defer jwks.Close()
+
+		handler := middleware.JWT(&middleware.JWTConfig{JWKSURL: jwks.URL})
+
+		serveWith := func(token string) int {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			internalMiddleware.NewChain().Add(handler).Then(http.NotFoundHandler()).ServeHTTP(recorder, req)
+
+			return recorder.Code
+		}
+
+		require.Equal(t, http.StatusNotFound, serveWith(signJWT(t, middleware.AlgorithmRS256, "rsa-1", rsaKey, validClaims())))
+		require.Equal(t, http.StatusNotFound, serveWith(signJWT(t, middleware.AlgorithmES256, "ec", ecKey, validClaims())))
+		require.Equal(t, int32(1), fetches.Load())
+
+		// Unknown keys refresh the key set, at most every 10 seconds.
+		current.Store("rsa-2")
+		require.Equal(t, http.StatusUnauthorized, serveWith(signJWT(t, middleware.AlgorithmRS256, "rsa-2", otherKey, validClaims())))
+		require.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("it should ignore the symmetric keys of a JWKS endpoint", func(t *testing.T) {
+		jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{ //nolint:errcheck
+				{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(secret)},
+			}})
+		}))
+		defer jwks.Close()
+
+		recorder, _ := serve(&middleware.JWTConfig{JWKSURL: jwks.URL}, signJWT(t, middleware.AlgorithmHS256, "hs", secret, validClaims()))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+		file := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(file, []byte(`{"keys":[{"kty":"oct","kid":"hs","k":"`+base64.RawURLEncoding.EncodeToString(secret)+`"}]}`), 0o600))
+
+		recorder, _ = serve(&middleware.JWTConfig{JWKSFile: file}, signJWT(t, middleware.AlgorithmHS256, "hs", secret, validClaims()))
+		require.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("it should refresh the keys of a JWKS endpoint periodically", func(t *testing.T) {
+		var current atomic.Pointer[rsa.PublicKey]
+		current.Store(&rsaKey.PublicKey)
+
+		jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := current.Load()
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{ //nolint:errcheck
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		}))
+		defer jwks.Close()
+
+		config := &middleware.JWTConfig{JWKSURL: jwks.URL, JWKSRefreshInterval: 20 * time.Millisecond}
+		handler := middleware.JWT(config)
+		serveWith := func(token string) int {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			internalMiddleware.NewChain().Add(handler).Then(http.NotFoundHandler()).ServeHTTP(recorder, req)
+
+			return recorder.Code
+		}
+
+		require.Equal(t, http.StatusNotFound, serveWith(signJWT(t, middleware.AlgorithmRS256, "", rsaKey, validClaims())))
+
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		current.Store(&otherKey.PublicKey)
+
+		require.Eventually(t, func() bool {
+			return serveWith(signJWT(t, middleware.AlgorithmRS256, "", otherKey, validClaims())) == http.StatusNotFound
+		}, time.Second, 10*time.Millisecond)
+		require.Equal(t, http.StatusUnauthorized, serveWith(signJWT(t, middleware.AlgorithmRS256, "", rsaKey, validClaims())))
+	})
+
+	t.Run("it should serve the current keys while the key set is refreshed", func(t *testing.T) {
+		release := make(chan struct{})
+		var fetches atomic.Int32
+
+		jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fetches.Add(1) > 1 {
+				<-release
+			}
+
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{ //nolint:errcheck
+				"kty": "RSA",
+				"kid": "rsa",
+				"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+			}}})
+		}))
+		defer jwks.Close()
+		defer close(release)
+
+		handler := internalMiddleware.NewChain().Add(middleware.JWT(&middleware.JWTConfig{JWKSURL: jwks.URL, JWKSRefreshInterval: 10 * time.Millisecond})).Then(http.NotFoundHandler())
+		serveWith := func(token string) int {
+			recorder := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			handler.ServeHTTP(recorder, req)
+
+			return recorder.Code
+		}
+
+		token := signJWT(t, middleware.AlgorithmRS256, "rsa", rsaKey, validClaims())
+		require.Equal(t, http.StatusNotFound, serveWith(token))
+
+		// The second refresh hangs until the end of the test, without blocking the requests.
+		time.Sleep(20 * time.Millisecond)
+		for range 3 {
+			require.Equal(t, http.StatusNotFound, serveWith(token))
+		}
+		require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("it should read the keys of a JWKS file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "jwks.json")
+		require.NoError(t, os.WriteFile(file, []byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"ed","x":"`+base64.RawURLEncoding.EncodeToString(edPublic)+`"},{"kty":"oct","kid":"enc","use":"enc","k":"c2VjcmV0"}]}`), 0o600))
+
+		config := &middleware.JWTConfig{JWKSFile: file}
+
+		recorder, _ := serve(config, signJWT(t, middleware.AlgorithmEdDSA, "ed", edKey, validClaims()))
+		require.Equal(t, http.StatusOK, recorder.Code)
+
+		// Encryption keys are not used to verify signatures.
+		recorder, _ = serve(config, signJWT(t, middleware.AlgorithmHS256, "enc", secret, validClaims()))
+		require.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+}
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"bufio"
 	"log/slog"
+	"net"
 	"net/http"
 	"time"
 
 	"github.com/arthurdotwork/heimdall"
+	"github.com/arthurdotwork/heimdall/internal/grpcstatus"
 )
 
 // Logger creates a middleware for logging HTTP requests
@@ -30,14 +33,21 @@ func Logger() heimdall.Middleware {
 			// Calculate request duration
 			duration := time.Since(start)
 
-			// Log the response
-			slog.InfoContext(r.Context(), "request completed",
+			attrs := []any{
 				"method", r.Method,
 				"path", r.URL.Path,
 				"status", rw.status,
 				"duration", duration.Seconds(),
 				"bytes", rw.size,
-			)
+			}
+
+			// gRPC calls report their outcome in the grpc-status header or trailer
+			if code, ok := grpcstatus.FromHeader(rw.Header()); ok {
+				attrs = append(attrs, "grpc_status", code, "grpc_code", grpcstatus.Name(code))
+			}
+
+			// Log the response
+			slog.InfoContext(r.Context(), "request completed", attrs...)
 		})
 	})
 }
@@ -63,6 +73,26 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Hijack lets upgraded connections (e.g. WebSocket) take over the underlying connection
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.status = http.StatusSwitchingProtocols
+	}
+
+	return conn, brw, err
+}
+
+// Flush sends buffered data to the client, so that streamed responses survive the middleware
+func (rw *responseWriter) Flush() {
+	_ = http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the original http.ResponseWriter, for use by http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // Write captures the response size
 func (rw *responseWriter) Write(b []byte) (int, error) {
 	size, err := rw.ResponseWriter.Write(b)
@@ -1,9 +1,11 @@
 package middleware_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -93,6 +95,30 @@ func TestLoggerMiddleware(t *testing.T) {
 		require.Contains(t, logs, "bytes=13")
 	})
 
+	t.Run("it should log the gRPC status", func(t *testing.T) {
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		slog.SetDefault(logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/grpc")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "14")
+		})
+
+		chain := internalMiddleware.NewChain().
+			Add(middleware.Logger())
+
+		req := httptest.NewRequest(http.MethodPost, "/helloworld.Greeter/SayHello", nil)
+		rec := httptest.NewRecorder()
+
+		chain.Then(handler).ServeHTTP(rec, req)
+
+		logs := logBuffer.String()
+		require.Contains(t, logs, "grpc_status=14")
+		require.Contains(t, logs, "grpc_code=UNAVAILABLE")
+	})
+
 	t.Run("it should preserve the request context", func(t *testing.T) {
 		type contextKey string
 		const testKey contextKey = "test-key"
@@ -114,4 +140,56 @@ func TestLoggerMiddleware(t *testing.T) {
 
 		require.Equal(t, "test-value", contextValue)
 	})
+
+	t.Run("it should let handlers flush the response", func(t *testing.T) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("data: event\n\n")) //nolint:errcheck
+			require.NoError(t, http.NewResponseController(w).Flush())
+		})
+
+		chain := internalMiddleware.NewChain().
+			Add(middleware.Logger())
+
+		req := httptest.NewRequest(http.MethodGet, "/events", nil)
+		rec := httptest.NewRecorder()
+
+		chain.Then(handler).ServeHTTP(rec, req)
+
+		require.True(t, rec.Flushed)
+	})
+
+	t.Run("it should let handlers hijack the connection", func(t *testing.T) {
+		var logBuffer bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logBuffer, nil))
+		slog.SetDefault(logger)
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := http.NewResponseController(w).Hijack()
+			require.NoError(t, err)
+			conn.Close() //nolint:errcheck
+		})
+
+		chain := internalMiddleware.NewChain().
+			Add(middleware.Logger())
+
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		rec := &hijackableRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+		chain.Then(handler).ServeHTTP(rec, req)
+
+		require.True(t, rec.hijacked)
+		require.Contains(t, logBuffer.String(), "status=101")
+	})
+}
+
+type hijackableRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (r *hijackableRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.hijacked = true
+	server, client := net.Pipe()
+	client.Close() //nolint:errcheck
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
 }
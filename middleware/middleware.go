@@ -12,6 +12,15 @@ func RegisterDefaults() {
 
 	// Register CORS middleware with default configuration
 	_ = heimdall.RegisterMiddleware("cors", CORS(DefaultCORSConfig()))
+
+	// Register CORS middleware for gRPC-Web endpoints
+	_ = heimdall.RegisterMiddleware("grpc-web-cors", CORS(GRPCWebCORSConfig()))
+
+	// Register compression middleware with default configuration
+	_ = heimdall.RegisterMiddleware("compress", Compress(DefaultCompressConfig()))
+
+	// Register cache middleware with an in-memory store
+	_ = heimdall.RegisterMiddleware("cache", Cache(DefaultCacheConfig()))
 }
 
 // Register registers the middleware with a custom registry
@@ -21,4 +30,13 @@ func Register(registry *middleware.Registry) {
 
 	// Register CORS middleware with default configuration
 	_ = registry.Register("cors", CORS(DefaultCORSConfig()))
+
+	// Register CORS middleware for gRPC-Web endpoints
+	_ = registry.Register("grpc-web-cors", CORS(GRPCWebCORSConfig()))
+
+	// Register compression middleware with default configuration
+	_ = registry.Register("compress", Compress(DefaultCompressConfig()))
+
+	// Register cache middleware with an in-memory store
+	_ = registry.Register("cache", Cache(DefaultCacheConfig()))
 }